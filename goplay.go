@@ -47,6 +47,23 @@ func main() {
 			Value:       "~/.ssh/id_rsa.pub",
 			Destination: &keyfile,
 		},
+		cli.StringFlag{
+			Name:  "vault-file",
+			Usage: "Supply vault key or passphrase `FILE` for encrypted env vars",
+			Value: "~/.goplay/vault.key",
+		},
+		cli.StringFlag{
+			Name:  "profile",
+			Usage: "Overlay playfile with profile `NAME`, e.g. Playfile.NAME.yml of Playfile.yml",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "Supply max `DURATION` of running playbook(s), e.g. 10m and 1h",
+		},
+		cli.IntFlag{
+			Name:  "forks",
+			Usage: "Supply max `NUMBER` of hosts connecting or running concurrently, 0 means no limit",
+		},
 		cli.BoolFlag{
 			Name:  "prompt",
 			Usage: "Print info(s) while running playbook(s)",
@@ -64,6 +81,196 @@ func main() {
 	// }
 
 	app.Commands = []cli.Command{
+		{
+			Name:      "run",
+			Usage:     "run command(s) or book(s) on all hosts of network",
+			ArgsUsage: "NETWORK COMMAND|BOOK [COMMAND|BOOK...]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "env",
+					Usage: "Supply env var of `KEY=VALUE`, which overrides all env vars defined by playfile",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Print plan of run, including env vars, batches, uploads and rendered command(s) of each host, without connecting",
+				},
+				cli.BoolFlag{
+					Name:  "check",
+					Usage: "Print diff of uploads against remote files of each host without changing anything, other command(s) are not run",
+				},
+				cli.BoolFlag{
+					Name:  "force-unlock",
+					Usage: "Take over lock of book held by others on hosts, even if it's not stale",
+				},
+				cli.StringFlag{
+					Name:  "resume",
+					Usage: "Resume run of `ID` from the first step not completed, hosts completed each step are skipped",
+				},
+				cli.StringFlag{
+					Name:  "report",
+					Usage: "Write report of run in `json|junit` format, which is written even if the run failed",
+				},
+				cli.StringFlag{
+					Name:  "report-file",
+					Usage: "Supply `FILE` of report, it's required by --report",
+				},
+			},
+			Action: books.Play.Run(log),
+		},
+		{
+			Name:      "list",
+			Usage:     "list networks of playfile with hosts expanded",
+			ArgsUsage: "[NETWORK...]",
+			Action:    books.Network.List(log),
+		},
+		{
+			Name:  "network",
+			Usage: "networks management of playfile",
+			Subcommands: []cli.Command{
+				{
+					Name:      "add-host",
+					Usage:     "add host(s) to network, network is created if it does not exist",
+					ArgsUsage: "NETWORK HOST [HOST...]",
+					Action:    books.Network.AddHost(log),
+				},
+				{
+					Name:      "remove-host",
+					Usage:     "remove host(s) from network",
+					ArgsUsage: "NETWORK HOST [HOST...]",
+					Action:    books.Network.RemoveHost(log),
+				},
+			},
+		},
+		{
+			Name:  "command",
+			Usage: "commands management of playfile",
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "add command to playfile",
+					ArgsUsage: "NAME",
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "desc",
+							Usage: "Supply description of command",
+						},
+						cli.StringFlag{
+							Name:  "run",
+							Usage: "Supply `COMMAND` to be run remotely",
+						},
+						cli.StringFlag{
+							Name:  "script",
+							Usage: "Supply script `FILE` to be run remotely",
+						},
+						cli.StringFlag{
+							Name:  "serial",
+							Usage: "Supply `SIZES` of batches running command in turn, e.g. 2, 25% and 1,5,20%",
+						},
+						cli.DurationFlag{
+							Name:  "pause-between-batches",
+							Usage: "Supply `DURATION` of pause before each batch but the first",
+						},
+						cli.StringFlag{
+							Name:  "health-check",
+							Usage: "Supply `COMMAND` run on hosts of each batch, the run is aborted if it fails",
+						},
+						cli.StringFlag{
+							Name:  "canary",
+							Usage: "Supply `SIZE` of hosts running command before the rest, e.g. 1 and 10%",
+						},
+						cli.StringFlag{
+							Name:  "canary-check",
+							Usage: "Supply `COMMAND` run locally to promote canary, it's confirmed on the terminal by default",
+						},
+						cli.StringSliceFlag{
+							Name:  "notify",
+							Usage: "Supply `HANDLER` notified on hosts changed by command, it can be given multiple times",
+						},
+						cli.IntFlag{
+							Name:  "changed-exit-code",
+							Usage: "Supply exit `CODE` of command meaning succeeded with changes, 0 disables it",
+						},
+						cli.StringFlag{
+							Name:  "changed-marker",
+							Usage: "Supply `MARKER` of command output meaning changes",
+						},
+						cli.StringFlag{
+							Name:  "rollback",
+							Usage: "Supply `COMMAND` run on hosts failed by command, or name of command in playfile",
+						},
+						cli.StringFlag{
+							Name:  "rollback-scope",
+							Usage: "Supply `failed|all` hosts rolled back, all includes hosts succeeded",
+						},
+						cli.BoolFlag{
+							Name:  "locally",
+							Usage: "Run command locally",
+						},
+						cli.BoolFlag{
+							Name:  "template",
+							Usage: "Render run, script and health check as templates for each host",
+						},
+						cli.BoolFlag{
+							Name:  "stdin",
+							Usage: "Attach STDIN to remote command",
+						},
+						cli.StringFlag{
+							Name:  "once",
+							Usage: "Run command on one host only, which is selected by `first|random` with failover",
+						},
+						cli.DurationFlag{
+							Name:  "timeout",
+							Usage: "Supply max `DURATION` of running command, e.g. 30s and 5m",
+						},
+						cli.IntFlag{
+							Name:  "retries",
+							Usage: "Supply max number of re-runs on host exited with non-zero status",
+						},
+						cli.DurationFlag{
+							Name:  "retry-delay",
+							Usage: "Supply `DURATION` before the first re-run, it's doubled for each re-run",
+						},
+						cli.StringFlag{
+							Name:  "on-failure",
+							Usage: "Supply policy on host failures by `abort|continue|skip_host`, default to skip_host",
+						},
+						cli.IntFlag{
+							Name:  "max-fail-percentage",
+							Usage: "Abort the run once failed hosts exceed `PERCENT` of all hosts",
+						},
+						cli.BoolFlag{
+							Name:  "force",
+							Usage: "Replace command if exists",
+						},
+					},
+					Action: books.Command.Add(log),
+				},
+			},
+		},
+		{
+			Name:  "vault",
+			Usage: "encrypted env vars management",
+			Subcommands: []cli.Command{
+				{
+					Name:      "encrypt",
+					Usage:     "encrypt value for env vars of playfile",
+					ArgsUsage: "[VALUE]",
+					Action:    books.Vault.Encrypt(log),
+				},
+				{
+					Name:      "decrypt",
+					Usage:     "decrypt value encrypted by vault",
+					ArgsUsage: "[VALUE]",
+					Action:    books.Vault.Decrypt(log),
+				},
+				{
+					Name:      "edit",
+					Usage:     "edit value encrypted by vault with $EDITOR",
+					ArgsUsage: "[VALUE]",
+					Action:    books.Vault.Edit(log),
+				},
+			},
+		},
 		{
 			Name:  "ssh",
 			Usage: "ssh management for ansible",
@@ -0,0 +1,129 @@
+package books
+
+import (
+	"fmt"
+
+	"github.com/dolab/goplay/play"
+	"github.com/dolab/logger"
+	"github.com/golib/cli"
+)
+
+var (
+	Command *_Command
+)
+
+type _Command struct{}
+
+func (_ *_Command) Add(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		name := ctx.Args().First()
+		if name == "" {
+			cli.ShowCommandHelp(ctx, "add")
+
+			return cli.NewExitError("Name of command is required", 04)
+		}
+
+		if ctx.String("run") == "" && ctx.String("script") == "" {
+			cli.ShowCommandHelp(ctx, "add")
+
+			return cli.NewExitError("Either run or script is required", 04)
+		}
+
+		once := play.Once(ctx.String("once"))
+		if once != "" && once != play.OnceFirst && once != play.OnceRandom {
+			return cli.NewExitError(fmt.Sprintf("Invalid once %s, it must be first or random", once), 04)
+		}
+
+		serial, err := play.ParseSerial(ctx.String("serial"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 04)
+		}
+
+		canary, err := play.ParseCanary(ctx.String("canary"), ctx.String("canary-check"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 04)
+		}
+
+		onFailure := ctx.String("on-failure")
+		if !play.IsValidFailurePolicy(onFailure) {
+			return cli.NewExitError(fmt.Sprintf("Invalid on-failure %s, it must be abort, continue or skip_host", onFailure), 04)
+		}
+
+		maxFail := ctx.Int("max-fail-percentage")
+		if maxFail < 0 || maxFail > 100 {
+			return cli.NewExitError(fmt.Sprintf("Invalid max-fail-percentage %d, it must be between 0 and 100", maxFail), 04)
+		}
+
+		changedExitCode := ctx.Int("changed-exit-code")
+		if changedExitCode < 0 || changedExitCode > 255 {
+			return cli.NewExitError(fmt.Sprintf("Invalid changed-exit-code %d, it must be between 0 and 255, 0 disables it", changedExitCode), 4)
+		}
+
+		rollbackScope := ctx.String("rollback-scope")
+		if !play.IsValidRollbackScope(rollbackScope) {
+			return cli.NewExitError(fmt.Sprintf("Invalid rollback-scope %s, it must be failed or all", rollbackScope), 04)
+		}
+		if rollbackScope != "" && ctx.String("rollback") == "" {
+			return cli.NewExitError("Invalid rollback-scope without rollback", 04)
+		}
+
+		filename := playfilePath(ctx)
+
+		pfile, err := play.NewPlayfileFromFile(filename)
+		if err != nil {
+			log.Errorf("play.NewPlayfileFromFile(%s): %v", filename, err)
+
+			return err
+		}
+
+		for _, handler := range ctx.StringSlice("notify") {
+			if _, ok := pfile.Handlers.Get(handler); !ok {
+				return cli.NewExitError(fmt.Sprintf("Handler named with %s does not exist", handler), 04)
+			}
+		}
+
+		if _, ok := pfile.Commands.Get(name); ok && !ctx.Bool("force") {
+			return cli.NewExitError(fmt.Sprintf("Command named with %s exists, use --force to replace it", name), 04)
+		}
+
+		cmd := play.Command{
+			Desc:    ctx.String("desc"),
+			Run:     ctx.String("run"),
+			Script:  ctx.String("script"),
+			Serial:  serial,
+			Locally: ctx.Bool("locally"),
+			Stdin:   ctx.Bool("stdin"),
+			Once:    once,
+			Timeout: ctx.Duration("timeout"),
+
+			Template: ctx.Bool("template"),
+
+			Retries:    ctx.Int("retries"),
+			RetryDelay: ctx.Duration("retry-delay"),
+
+			OnFailure:         play.FailurePolicy(onFailure),
+			MaxFailPercentage: play.Percentage(maxFail),
+
+			PauseBetweenBatches: ctx.Duration("pause-between-batches"),
+			HealthCheck:         ctx.String("health-check"),
+			Canary:              canary,
+
+			Notify:          ctx.StringSlice("notify"),
+			ChangedExitCode: changedExitCode,
+			ChangedMarker:   ctx.String("changed-marker"),
+
+			Rollback:      ctx.String("rollback"),
+			RollbackScope: play.RollbackScope(rollbackScope),
+		}
+		pfile.Commands.Set(name, cmd)
+
+		err = pfile.WriteFile(filename)
+		if err != nil {
+			log.Errorf("pfile.WriteFile(%s): %v", filename, err)
+
+			return err
+		}
+
+		return nil
+	}
+}
@@ -6,14 +6,17 @@ import (
 	"path"
 	"regexp"
 	"strings"
-	"text/template"
+
+	"github.com/golib/cli"
 )
 
 var (
 	absroot      = "~/.goplay"
 	identityfile = path.Join(absroot, "ansible_rsa.pub")
 	playfile     = path.Join(absroot, "Playfile.yml")
-	playfiletpl  = template.Must(template.ParseFiles("./Playfile.yml"))
+	vaultfile    = path.Join(absroot, "vault.key")
+	runsdir      = path.Join(absroot, "runs")
+	playfiletpl  = "./Playfile.yml"
 
 	// ansible
 	rversion          = regexp.MustCompile(`^ansible +?([\d.]+?)[\d.]*?`)
@@ -27,6 +30,8 @@ func init() {
 	absroot = abspath(absroot)
 	identityfile = abspath(identityfile)
 	playfile = abspath(playfile)
+	vaultfile = abspath(vaultfile)
+	runsdir = abspath(runsdir)
 	defaultConfigFile = abspath(defaultConfigFile)
 
 	err := os.MkdirAll(absroot, 0755)
@@ -35,6 +40,16 @@ func init() {
 	}
 }
 
+// playfilePath returns abs path of Playfile supplied by global playfile flag.
+func playfilePath(ctx *cli.Context) string {
+	filename := ctx.GlobalString("playfile")
+	if filename == "" {
+		filename = playfile
+	}
+
+	return abspath(filename)
+}
+
 func abspath(filename string) string {
 	filename = path.Clean(filename)
 
@@ -0,0 +1,141 @@
+package books
+
+import (
+	"fmt"
+
+	"github.com/dolab/goplay/play"
+	"github.com/dolab/logger"
+	"github.com/golib/cli"
+)
+
+var (
+	Network *_Network
+)
+
+type _Network struct{}
+
+func (_ *_Network) AddHost(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		args := ctx.Args()
+		if len(args) < 2 {
+			cli.ShowCommandHelp(ctx, "add-host")
+
+			return cli.NewExitError("Both network and host(s) are required", 04)
+		}
+
+		filename := playfilePath(ctx)
+
+		pfile, err := play.NewPlayfileFromFile(filename)
+		if err != nil {
+			log.Errorf("play.NewPlayfileFromFile(%s): %v", filename, err)
+
+			return err
+		}
+
+		// network is created if it does not exist
+		network, _ := pfile.Networks.Definition(args[0])
+		for _, host := range args[1:] {
+			if !network.AddHost(host) {
+				log.Warnf("Host %s exists in network %s, skipped", play.MaskUserHostWithPasswd(host), args[0])
+			}
+		}
+
+		err = pfile.Networks.Set(args[0], network)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 04)
+		}
+
+		err = pfile.WriteFile(filename)
+		if err != nil {
+			log.Errorf("pfile.WriteFile(%s): %v", filename, err)
+
+			return err
+		}
+
+		return nil
+	}
+}
+
+func (_ *_Network) RemoveHost(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		args := ctx.Args()
+		if len(args) < 2 {
+			cli.ShowCommandHelp(ctx, "remove-host")
+
+			return cli.NewExitError("Both network and host(s) are required", 04)
+		}
+
+		filename := playfilePath(ctx)
+
+		pfile, err := play.NewPlayfileFromFile(filename)
+		if err != nil {
+			log.Errorf("play.NewPlayfileFromFile(%s): %v", filename, err)
+
+			return err
+		}
+
+		network, ok := pfile.Networks.Definition(args[0])
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("Network named with %s does not exist", args[0]), 04)
+		}
+
+		// NOTE: hosts inherited or composed from other networks can't be removed
+		for _, host := range args[1:] {
+			if !network.RemoveHost(host) {
+				log.Warnf("Host %s is not defined by network %s, skipped", play.MaskUserHostWithPasswd(host), args[0])
+			}
+		}
+
+		err = pfile.Networks.Set(args[0], network)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 04)
+		}
+
+		err = pfile.WriteFile(filename)
+		if err != nil {
+			log.Errorf("pfile.WriteFile(%s): %v", filename, err)
+
+			return err
+		}
+
+		return nil
+	}
+}
+
+func (_ *_Network) List(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		filename := playfilePath(ctx)
+		profile := ctx.GlobalString("profile")
+
+		pfile, err := play.NewPlayfileWithProfile(filename, profile)
+		if err != nil {
+			log.Errorf("play.NewPlayfileWithProfile(%s, %s): %v", filename, profile, err)
+
+			return err
+		}
+
+		names := ctx.Args()
+		if len(names) == 0 {
+			names = pfile.Networks.Names
+		}
+
+		for _, name := range names {
+			network, ok := pfile.Networks.Get(name)
+			if !ok {
+				return cli.NewExitError(fmt.Sprintf("Network named with %s does not exist", name), 04)
+			}
+
+			fmt.Printf("%s (%d hosts)\n", name, len(network.Hosts))
+			for _, host := range network.Hosts {
+				fmt.Printf("  - %s\n", play.MaskUserHostWithPasswd(host))
+			}
+
+			// NOTE: hosts of inventory are resolved on running
+			if network.Inventory != "" {
+				fmt.Printf("  + inventory: %s\n", network.Inventory)
+			}
+		}
+
+		return nil
+	}
+}
@@ -1,6 +1,18 @@
 package books
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/dolab/goplay/play"
+	"github.com/dolab/logger"
 	"github.com/golib/cli"
 )
 
@@ -10,153 +22,294 @@ var (
 
 type _Play struct{}
 
-func (_ *_Play) Run() cli.ActionFunc {
+func (_ *_Play) Run(log *logger.Logger) cli.ActionFunc {
 	return func(ctx *cli.Context) error {
-		// if Playfile == "" {
-		// 	Playfile = "./Playfile"
-		// }
-		// data, err := ioutil.ReadFile(resolvePath(Playfile))
-		// if err != nil {
-		// 	firstErr := err
-		// 	data, err = ioutil.ReadFile("./Playfile.yml") // Alternative to ./Playfile.
-		// 	if err != nil {
-		// 		fmt.Fprintln(os.Stderr, firstErr)
-		// 		fmt.Fprintln(os.Stderr, err)
-		// 		os.Exit(1)
-		// 	}
-		// }
-		// conf, err := play.NewPlayfile(data)
-		// if err != nil {
-		// 	fmt.Fprintln(os.Stderr, err)
-		// 	os.Exit(1)
-		// }
-
-		// // Parse network and commands to be run from args.
-		// network, commands, err := parseArgs(conf)
-		// if err != nil {
-		// 	fmt.Fprintln(os.Stderr, err)
-		// 	os.Exit(1)
-		// }
-
-		// // --only flag filters hosts
-		// if onlyHosts != "" {
-		// 	expr, err := regexp.CompilePOSIX(onlyHosts)
-		// 	if err != nil {
-		// 		fmt.Fprintln(os.Stderr, err)
-		// 		os.Exit(1)
-		// 	}
-
-		// 	var hosts []string
-		// 	for _, host := range network.Hosts {
-		// 		if expr.MatchString(host) {
-		// 			hosts = append(hosts, host)
-		// 		}
-		// 	}
-		// 	if len(hosts) == 0 {
-		// 		fmt.Fprintln(os.Stderr, fmt.Errorf("no hosts match --only '%v' regexp", onlyHosts))
-		// 		os.Exit(1)
-		// 	}
-		// 	network.Hosts = hosts
-		// }
-
-		// // --except flag filters out hosts
-		// if exceptHosts != "" {
-		// 	expr, err := regexp.CompilePOSIX(exceptHosts)
-		// 	if err != nil {
-		// 		fmt.Fprintln(os.Stderr, err)
-		// 		os.Exit(1)
-		// 	}
-
-		// 	var hosts []string
-		// 	for _, host := range network.Hosts {
-		// 		if !expr.MatchString(host) {
-		// 			hosts = append(hosts, host)
-		// 		}
-		// 	}
-		// 	if len(hosts) == 0 {
-		// 		fmt.Fprintln(os.Stderr, fmt.Errorf("no hosts left after --except '%v' regexp", onlyHosts))
-		// 		os.Exit(1)
-		// 	}
-		// 	network.Hosts = hosts
-		// }
-
-		// // --sshconfig flag location for ssh_config file
-		// if sshConfig != "" {
-		// 	confHosts, err := sshconfig.ParseSSHConfig(resolvePath(sshConfig))
-		// 	if err != nil {
-		// 		fmt.Fprintln(os.Stderr, err)
-		// 		os.Exit(1)
-		// 	}
-
-		// 	// flatten Host -> *SSHHost, not the prettiest
-		// 	// but will do
-		// 	confMap := map[string]*sshconfig.SSHHost{}
-		// 	for _, conf := range confHosts {
-		// 		for _, host := range conf.Host {
-		// 			confMap[host] = conf
-		// 		}
-		// 	}
-
-		// 	// check network.Hosts for match
-		// 	for _, host := range network.Hosts {
-		// 		conf, found := confMap[host]
-		// 		if found {
-		// 			network.User = conf.User
-		// 			network.IdentityFile = resolvePath(conf.IdentityFile)
-		// 			network.Hosts = []string{fmt.Sprintf("%s:%d", conf.HostName, conf.Port)}
-		// 		}
-		// 	}
-		// }
-
-		// var vars play.EnvVars
-		// for _, val := range append(conf.Env, network.Env...) {
-		// 	vars.Set(val.Key, val.Value)
-		// }
-		// if err := vars.ResolveValues(); err != nil {
-		// 	fmt.Fprintln(os.Stderr, err)
-		// 	os.Exit(1)
-		// }
-
-		// // Parse CLI --env flag env vars, define $SUP_ENV and override values defined in Playfile.
-		// var cliVars play.EnvVars
-		// for _, env := range envVars {
-		// 	if len(env) == 0 {
-		// 		continue
-		// 	}
-		// 	i := strings.Index(env, "=")
-		// 	if i < 0 {
-		// 		if len(env) > 0 {
-		// 			vars.Set(env, "")
-		// 		}
-		// 		continue
-		// 	}
-		// 	vars.Set(env[:i], env[i+1:])
-		// 	cliVars.Set(env[:i], env[i+1:])
-		// }
-
-		// // SUP_ENV is generated only from CLI env vars.
-		// // Separate loop to omit duplicates.
-		// supEnv := ""
-		// for _, v := range cliVars {
-		// 	supEnv += fmt.Sprintf(" -e %v=%q", v.Key, v.Value)
-		// }
-		// vars.Set("SUP_ENV", strings.TrimSpace(supEnv))
-
-		// // Create new Stackup app.
-		// player, err := play.New(conf)
-		// if err != nil {
-		// 	fmt.Fprintln(os.Stderr, err)
-		// 	os.Exit(1)
-		// }
-		// player.Debug(debug)
-		// player.Prompt(!disablePrefix)
-
-		// // Run all the commands in the given network.
-		// err = player.Run(network, vars, commands...)
-		// if err != nil {
-		// 	fmt.Fprintln(os.Stderr, err)
-		// 	os.Exit(1)
-		// }
-		return nil
+		args := ctx.Args()
+
+		// resuming run takes network and command(s) of the run by default
+		var state *play.RunState
+		if id := ctx.String("resume"); id != "" {
+			if ctx.Bool("dry-run") || ctx.Bool("check") {
+				return cli.NewExitError("Resume can't be used with --dry-run or --check", 04)
+			}
+
+			var err error
+
+			state, err = play.LoadRunState(runsdir, id)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 04)
+			}
+
+			if state.Done {
+				return cli.NewExitError(fmt.Sprintf("Run %s has completed, nothing to resume", id), 04)
+			}
+
+			runArgs := append([]string{state.Network}, state.Commands...)
+			if len(args) == 0 {
+				args = cli.Args(runArgs)
+			} else if strings.Join(args, " ") != strings.Join(runArgs, " ") {
+				return cli.NewExitError(fmt.Sprintf("Run %s can't be resumed with other network or command(s), it's run of %s", id, strings.Join(runArgs, " ")), 04)
+			}
+		}
+
+		if len(args) < 2 {
+			cli.ShowCommandHelp(ctx, "run")
+
+			return cli.NewExitError("Both network and command(s) are required", 04)
+		}
+
+		// validate report before running, the run may not be repeatable
+		reportFormat := ctx.String("report")
+		reportFile := ctx.String("report-file")
+		if reportFormat != "" {
+			if !play.IsValidReportFormat(reportFormat) {
+				return cli.NewExitError(fmt.Sprintf("Report format %s is not supported, json or junit is expected", reportFormat), 04)
+			}
+
+			if reportFile == "" {
+				return cli.NewExitError("Report file is required by --report", 04)
+			}
+		}
+
+		filename := playfilePath(ctx)
+		profile := ctx.GlobalString("profile")
+
+		if state != nil && (state.Playfile != filename || state.Profile != profile) {
+			return cli.NewExitError(fmt.Sprintf("Run %s can't be resumed with other playfile or profile, it's run of %s with profile %q", state.ID, state.Playfile, state.Profile), 04)
+		}
+
+		pfile, err := play.NewPlayfileWithProfile(filename, profile)
+		if err != nil {
+			log.Errorf("play.NewPlayfileWithProfile(%s, %s): %v", filename, profile, err)
+
+			return err
+		}
+
+		// vault for env vars encrypted, it's optional
+		vault, err := loadVault(ctx, false)
+		if err != nil {
+			return err
+		}
+		pfile.SetVault(vault)
+
+		// resolve network and its dynamic hosts
+		network, ok := pfile.Networks.Get(args[0])
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("Network named with %s does not exist", args[0]), 04)
+		}
+
+		hosts, err := network.ParseInventory()
+		if err != nil {
+			log.Errorf("network.ParseInventory(%s): %v", args[0], err)
+
+			return err
+		}
+		network.Hosts = append(network.Hosts, hosts...)
+
+		// resolve commands, books are expanded to their commands
+		commands, err := parseCommands(pfile, args[1:]...)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 04)
+		}
+
+		// canary of book applies to all of its commands, see play.Canary.
+		var canary *play.Canary
+		for _, name := range args[1:] {
+			if c := pfile.Books.Canary(name); c != nil {
+				if len(args) > 2 {
+					return cli.NewExitError(fmt.Sprintf("Book %s with canary can't be run with other command(s) or book(s)", name), 04)
+				}
+
+				canary = c
+			}
+		}
+
+		// lock of book applies to the whole run, see play.Lock.
+		var (
+			lock     *play.Lock
+			lockBook string
+		)
+		for _, name := range args[1:] {
+			if l := pfile.Books.Lock(name); l != nil {
+				if lock != nil && l.Name != lock.Name {
+					return cli.NewExitError(fmt.Sprintf("Book %s with lock can't be run with book %s of other lock", name, lockBook), 04)
+				}
+
+				lock, lockBook = l, name
+			}
+		}
+		if ctx.Bool("force-unlock") && lock == nil {
+			return cli.NewExitError("Force unlock requires book with lock", 04)
+		}
+
+		// CLI env vars, which override all env vars defined by Playfile
+		var (
+			vars    play.EnvVars
+			cliVars play.EnvVars
+		)
+		for _, env := range ctx.StringSlice("env") {
+			if len(env) == 0 {
+				continue
+			}
+
+			kv := strings.SplitN(env, "=", 2)
+			if len(kv) == 1 {
+				kv = append(kv, "")
+			}
+
+			cliVars.Set(kv[0], kv[1])
+		}
+
+		playUser := ""
+		if cu, err := user.Current(); err == nil {
+			playUser = cu.Username
+		}
+
+		vars.Set("PLAY_NETWORK", args[0])
+		vars.Set("PLAY_PROFILE", pfile.Profile())
+		vars.Set("PLAY_USER", playUser)
+		vars.Set("PLAY_TIME", time.Now().Format(time.RFC3339))
+		for _, v := range cliVars {
+			vars.Set(v.Key, v.Value)
+		}
+
+		// PLAY_ENV is generated only from CLI env vars.
+		playEnv := ""
+		for _, v := range cliVars {
+			playEnv += fmt.Sprintf(" -e %v=%q", v.Key, v.Value)
+		}
+		vars.Set("PLAY_ENV", strings.TrimSpace(playEnv))
+
+		player, err := play.New(pfile)
+		if err != nil {
+			return err
+		}
+		player.Prompt(ctx.GlobalBool("prompt"))
+		player.Debug(ctx.GlobalBool("debug"))
+		player.DryRun(ctx.Bool("dry-run"))
+		player.Check(ctx.Bool("check"))
+		player.Forks(ctx.GlobalInt("forks"))
+		player.Canary(canary)
+		player.Lock(lock)
+		player.ForceUnlock(ctx.Bool("force-unlock"))
+
+		// progress of run is saved for resuming, see play.RunState.
+		if state == nil && !ctx.Bool("dry-run") && !ctx.Bool("check") {
+			state = play.NewRunState(runsdir, args[0], args[1:])
+			state.Playfile = filename
+			state.Profile = profile
+		}
+		if state != nil {
+			log.Infof("Run %s, progress is saved to %s", state.ID, state.Filename())
+
+			player.State(state)
+		}
+
+		// Cancel on the first interrupt, the next one kills goplay by default.
+		runCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		if timeout := ctx.GlobalDuration("timeout"); timeout > 0 {
+			runCtx, cancel = context.WithTimeout(runCtx, timeout)
+			defer cancel()
+		}
+
+		trap := make(chan os.Signal, 1)
+		signal.Notify(trap, os.Interrupt)
+		defer signal.Stop(trap)
+
+		go func() {
+			select {
+			case <-trap:
+				log.Warnf("Interrupted, stopping running books ...")
+
+				signal.Stop(trap)
+				cancel()
+
+			case <-runCtx.Done():
+			}
+		}()
+
+		result, err := player.RunContext(runCtx, &network, vars, commands...)
+
+		if state != nil {
+			state.Done = err == nil
+
+			saveErr := state.Save()
+			if saveErr != nil {
+				log.Errorf("state.Save(%s): %v", state.Filename(), saveErr)
+			} else if !state.Done {
+				log.Warnf("Run %s is not completed, resume it by: goplay run --resume %s", state.ID, state.ID)
+			}
+		}
+
+		// NOTE: report is written even if the run failed, it's nil for dry run.
+		if result != nil && reportFormat != "" {
+			reportErr := writeReport(reportFile, reportFormat, result)
+			if reportErr != nil {
+				log.Errorf("writeReport(%s, %s): %v", reportFile, reportFormat, reportErr)
+
+				if err == nil {
+					return reportErr
+				}
+			}
+		}
+
+		switch err {
+		case context.Canceled:
+			return cli.NewExitError("Interrupted", 130)
+
+		case context.DeadlineExceeded:
+			return cli.NewExitError(fmt.Sprintf("Timed out after %v", ctx.GlobalDuration("timeout")), 124)
+		}
+
+		switch err.(type) {
+		case play.ErrFailures, play.ErrCanaryDeclined:
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		return err
 	}
 }
+
+// parseCommands resolves names given to commands of Playfile, name of book is
+// expanded to all commands it contains.
+func parseCommands(pfile *play.Playfile, names ...string) (commands []*play.Command, err error) {
+	for _, name := range names {
+		if cmd, ok := pfile.Commands.Get(name); ok {
+			cmd.Name = name
+
+			commands = append(commands, &cmd)
+			continue
+		}
+
+		cmdNames, ok := pfile.Books.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("Command or book named with %s does not exist", name)
+		}
+
+		for _, cmdName := range cmdNames {
+			cmd, ok := pfile.Commands.Get(cmdName)
+			if !ok {
+				return nil, fmt.Errorf("Command named with %s of book %s does not exist", cmdName, name)
+			}
+			cmd.Name = cmdName
+
+			commands = append(commands, &cmd)
+		}
+	}
+
+	return
+}
+
+// writeReport writes report of result in format given to filename.
+func writeReport(filename, format string, result *play.Result) error {
+	var buf bytes.Buffer
+
+	err := play.WriteReport(&buf, format, result)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
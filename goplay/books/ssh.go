@@ -1,7 +1,6 @@
 package books
 
 import (
-	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -144,7 +143,7 @@ func (_ *_SSH) Setup(log *logger.Logger) cli.ActionFunc {
 		player.Prompt(ctx.GlobalBool("prompt"))
 		player.Debug(ctx.GlobalBool("debug"))
 
-		err = player.Run(&network, envs, &command)
+		_, err = player.Run(&network, envs, &command)
 		if err != nil {
 			return
 		}
@@ -154,20 +153,27 @@ func (_ *_SSH) Setup(log *logger.Logger) cli.ActionFunc {
 			hosts[i] = strings.SplitN(host, "@", 2)[1]
 		}
 
-		var buf bytes.Buffer
-		err = playfiletpl.Execute(&buf, map[string]string{
-			"hosts":         "- " + strings.Join(hosts, "\n    - "),
-			"identity_file": keyfile,
-		})
+		pfile, err := play.NewPlayfileFromFile(playfiletpl)
 		if err != nil {
-			log.Errorf("playfile.Execute(): %v", err)
+			log.Errorf("play.NewPlayfileFromFile(%s): %v", playfiletpl, err)
 
 			return err
 		}
 
-		err = ioutil.WriteFile(playfile, buf.Bytes(), 0755)
+		network, _ = pfile.Networks.Definition("all")
+		network.Hosts = hosts
+		network.IdentityFile = keyfile
+
+		err = pfile.Networks.Set("all", network)
+		if err != nil {
+			log.Errorf("pfile.Networks.Set(all): %v", err)
+
+			return err
+		}
+
+		err = pfile.WriteFile(playfile)
 		if err != nil {
-			log.Errorf("ioutil.WriteFile(%s): %v", playfile, err)
+			log.Errorf("pfile.WriteFile(%s): %v", playfile, err)
 
 			return err
 		}
@@ -0,0 +1,177 @@
+package books
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dolab/goplay/play"
+	"github.com/dolab/logger"
+	"github.com/golib/cli"
+)
+
+var (
+	Vault *_Vault
+)
+
+type _Vault struct{}
+
+func (_ *_Vault) Encrypt(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		vault, err := loadVault(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		value, err := readVaultValue(ctx)
+		if err != nil {
+			return err
+		}
+
+		encrypted, err := vault.Encrypt(value)
+		if err != nil {
+			log.Errorf("vault.Encrypt(): %v", err)
+
+			return err
+		}
+
+		fmt.Println(play.VaultTag + " " + encrypted)
+
+		return nil
+	}
+}
+
+func (_ *_Vault) Decrypt(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		vault, err := loadVault(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		value, err := readVaultValue(ctx)
+		if err != nil {
+			return err
+		}
+
+		plain, err := vault.Decrypt(strings.TrimSpace(strings.TrimPrefix(value, play.VaultTag)))
+		if err != nil {
+			log.Errorf("vault.Decrypt(): %v", err)
+
+			return err
+		}
+
+		fmt.Println(plain)
+
+		return nil
+	}
+}
+
+func (_ *_Vault) Edit(log *logger.Logger) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		vault, err := loadVault(ctx, true)
+		if err != nil {
+			return err
+		}
+
+		value, err := readVaultValue(ctx)
+		if err != nil {
+			return err
+		}
+
+		plain, err := vault.Decrypt(strings.TrimSpace(strings.TrimPrefix(value, play.VaultTag)))
+		if err != nil {
+			log.Errorf("vault.Decrypt(): %v", err)
+
+			return err
+		}
+
+		// NOTE: ioutil.TempFile creates file with mode of 0600
+		tmpfile, err := ioutil.TempFile("", "goplay-vault-")
+		if err != nil {
+			log.Errorf("ioutil.TempFile(): %v", err)
+
+			return err
+		}
+		defer os.Remove(tmpfile.Name())
+
+		_, err = tmpfile.WriteString(plain)
+		tmpfile.Close()
+		if err != nil {
+			log.Errorf("tmpfile.WriteString(): %v", err)
+
+			return err
+		}
+
+		editor := os.Getenv("EDITOR")
+		if editor == "" {
+			editor = "vi"
+		}
+
+		cmd := exec.Command("bash", "-c", editor+` "$0"`, tmpfile.Name())
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+
+		err = cmd.Run()
+		if err != nil {
+			log.Errorf("%s %s: %v", editor, tmpfile.Name(), err)
+
+			return err
+		}
+
+		data, err := ioutil.ReadFile(tmpfile.Name())
+		if err != nil {
+			log.Errorf("ioutil.ReadFile(%s): %v", tmpfile.Name(), err)
+
+			return err
+		}
+
+		encrypted, err := vault.Encrypt(strings.TrimSuffix(string(data), "\n"))
+		if err != nil {
+			log.Errorf("vault.Encrypt(): %v", err)
+
+			return err
+		}
+
+		fmt.Println(play.VaultTag + " " + encrypted)
+
+		return nil
+	}
+}
+
+// loadVault returns *play.Vault of global vault-file flag, it returns nil
+// without error if the file does not exist and is not required.
+func loadVault(ctx *cli.Context, required bool) (*play.Vault, error) {
+	filename := ctx.GlobalString("vault-file")
+	if filename == "" {
+		filename = vaultfile
+	}
+	filename = abspath(filename)
+
+	if _, err := os.Stat(filename); os.IsNotExist(err) && !required {
+		return nil, nil
+	}
+
+	vault, err := play.NewVaultFromFile(filename)
+	if err != nil {
+		return nil, cli.NewExitError(fmt.Sprintf("Cannot load vault key from %s: %v", filename, err), 04)
+	}
+
+	return vault, nil
+}
+
+// readVaultValue returns the first arg, or reads it from STDIN if no arg given.
+func readVaultValue(ctx *cli.Context) (string, error) {
+	if ctx.NArg() > 0 {
+		return ctx.Args().First(), nil
+	}
+
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
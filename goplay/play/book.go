@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -12,15 +13,73 @@ import (
 // Book represents a set of commands to be run.
 type Book struct {
 	clients []Client
+	env     string // export FOO="bar"; export BAR="baz";
 	run     string
+	source  string            // Run as given, before rendered or decorated for debug, see Play.bookStep.
+	runs    map[Client]string // Rendered run of templated book for each client.
 	input   io.Reader
-	once    bool
+	once    bool // Clients are candidates of failover, only one of them runs the book.
 	tty     bool
+
+	openInput  func() (io.Reader, error) // Opens input for each run of book, e.g. tar stream of uploads.
+	retries    int                       // Max number of re-runs on client exited with non-zero status.
+	retryDelay time.Duration             // Delay before the first re-run, see retryBackoff.
+
+	batch       int  // Index of batch of serial command, see Serial.
+	healthCheck bool // Book is health check of the batch.
+	canary      bool // Book runs on canary of the command, see Canary.
+
+	upload *Upload // Upload of book with local path resolved, it's nil for shell book.
+
+	changedExitCode int    // Exit status meaning succeeded with changes, see Command.ChangedExitCode.
+	changedMarker   string // Output meaning changes, see Command.ChangedMarker.
+	detectChanges   bool   // Upload is diffed with remote files for changes before run.
+
+	step string // Step of book in RunState, it's empty if the book is not tracked.
+}
+
+// changed returns nil if err is exit of changed exit status, and marks host as
+// changed. It returns err as is otherwise.
+func (book *Book) changed(host *HostResult, err error) error {
+	if book.changedExitCode > 0 && exitStatus(err) == book.changedExitCode {
+		host.Changed = true
+
+		return nil
+	}
+
+	return err
+}
+
+// String implements fmt.Stringer, env vars are omitted for secrets.
+func (book *Book) String() string {
+	return book.run
+}
+
+// command returns command to be run by the client given.
+func (book *Book) command(client Client) string {
+	return book.env + book.script(client)
+}
+
+// script returns run of book for the client given, without env vars.
+func (book *Book) script(client Client) string {
+	if run, ok := book.runs[client]; ok {
+		return run
+	}
+
+	return book.run
 }
 
-func (play *Play) createBooks(clients []Client, cmd *Command, env string) (books []*Book, err error) {
+// createBooks creates books of cmd for clients with env vars given, env vars
+// of clientEnvs are exported by clients already, see Play.connect, books export
+// the rest only.
+func (play *Play) createBooks(clients []Client, cmd *Command, envs, clientEnvs EnvVars) (books []*Book, err error) {
 	var allBooks []*Book
 
+	env := envs.AsExport()
+	clientEnv := clientEnvs.AsExport()
+	bookEnvs := envs.diff(clientEnvs)
+	bookEnv := bookEnvs.AsExport()
+
 	// Upload.
 	// Always run upload first.
 	if len(cmd.Uploads) > 0 {
@@ -44,13 +103,13 @@ func (play *Play) createBooks(clients []Client, cmd *Command, env string) (books
 
 		// overwrite clients with local host connection
 		if cmd.Locally {
-			local := NewLocalClient(env)
+			local := NewLocalClient(clientEnv)
 			local.Connect("localhost")
 
 			clients = []Client{local}
 		}
 
-		scriptBooks, scriptErr := play.createShellBooks(clients, string(data), env, cmd.Stdin)
+		scriptBooks, scriptErr := play.createShellBooks(clients, string(data), envs, cmd.Template, cmd.Stdin)
 		if scriptErr != nil {
 			err = errors.Wrap(scriptErr, "can't create script book: "+string(data))
 			return
@@ -63,13 +122,13 @@ func (play *Play) createBooks(clients []Client, cmd *Command, env string) (books
 	if cmd.Run != "" {
 		// overwrite clients with local host connection
 		if cmd.Locally {
-			local := NewLocalClient(env)
+			local := NewLocalClient(clientEnv)
 			local.Connect("localhost")
 
 			clients = []Client{local}
 		}
 
-		shellBooks, shellErr := play.createShellBooks(clients, cmd.Run, env, cmd.Stdin)
+		shellBooks, shellErr := play.createShellBooks(clients, cmd.Run, envs, cmd.Template, cmd.Stdin)
 		if shellErr != nil {
 			err = errors.Wrap(shellErr, "can't create shell book: "+cmd.Run)
 			return
@@ -78,32 +137,84 @@ func (play *Play) createBooks(clients []Client, cmd *Command, env string) (books
 		allBooks = append(allBooks, shellBooks...)
 	}
 
+	// NOTE: STDIN can't be replayed, books reading it are never re-run.
+	if cmd.Stdin && cmd.Retries > 0 && (cmd.Run != "" || cmd.Script != "") {
+		Warnf("Command %s reads STDIN, retries of it apply to its uploads and health check only\n", cmd.Name)
+	}
+
 	for _, book := range allBooks {
-		switch {
-		case cmd.Once: // TODO: try cmd over all of clients until one success?
-			book.clients = []Client{clients[0]}
-			book.once = cmd.Once
-
-			books = append(books, book)
-
-		case cmd.Serial > 0: // Each "serial" book client group is executed sequentially.
-			for i := 0; i < len(clients); i += cmd.Serial {
-				j := i + cmd.Serial
-				if j > len(clients) {
-					j = len(clients)
-				}
+		book.env = bookEnv
+		book.retries = cmd.Retries
+		book.retryDelay = cmd.RetryDelay
+
+		if book.upload != nil {
+			book.detectChanges = len(cmd.Notify) > 0
+		} else {
+			book.changedExitCode = cmd.ChangedExitCode
+			book.changedMarker = cmd.ChangedMarker
+		}
+	}
 
-				copy := *book
-				copy.clients = clients[i:j]
+	// Run on the first candidate, see Play.executeOnce for failover.
+	if cmd.Once != "" {
+		// Candidates of once command in order, all books share the same order.
+		candidates := clients
+		if cmd.Once == OnceRandom {
+			candidates = shuffleClients(clients)
+		}
 
-				books = append(books, &copy)
-			}
+		for _, book := range allBooks {
+			book.clients = candidates
+			book.once = true
+		}
+
+		books = allBooks
+		return
+	}
+
+	// Health check.
+	var healthBooks []*Book
+	if cmd.HealthCheck != "" {
+		healthBooks, err = play.createShellBooks(clients, cmd.HealthCheck, envs, cmd.Template, false)
+		if err != nil {
+			err = errors.Wrap(err, "can't create health check book: "+cmd.HealthCheck)
+			return
+		}
+
+		for _, book := range healthBooks {
+			book.env = bookEnv
+			book.retries = cmd.Retries
+			book.retryDelay = cmd.RetryDelay
+			book.healthCheck = true
+		}
+	}
+
+	// Canary runs all books as the first batch, see Canary.
+	var batches []int
+	if cmd.Canary != nil {
+		canary, rest := cmd.Canary.split(clients)
+
+		batches = append(batches, len(canary))
+		if len(rest) > 0 {
+			batches = append(batches, cmd.Serial.Batches(len(rest))...)
+		}
+	} else {
+		batches = cmd.Serial.Batches(len(clients))
+	}
 
-		default:
-			book.clients = clients
+	// Each batch runs all books in turn, see Serial.
+	i := 0
+	for batch, size := range batches {
+		for _, book := range append(allBooks[:len(allBooks):len(allBooks)], healthBooks...) {
+			copy := *book
+			copy.clients = clients[i : i+size]
+			copy.batch = batch
+			copy.canary = cmd.Canary != nil && batch == 0
 
-			books = append(books, book)
+			books = append(books, &copy)
 		}
+
+		i += size
 	}
 
 	return
@@ -123,16 +234,26 @@ func (play *Play) createUploadBooks(clients []Client, uploads map[string]Upload,
 			return
 		}
 
-		uploadTarReader, uploadTarErr := NewTarStreamReader(cwd, uploadFile, upload.Filter)
-		if uploadTarErr != nil {
-			err = errors.Wrap(uploadTarErr, "create tar stream of local path failed: "+upload.Src)
-			return
-		}
+		// NOTE: tar stream is created for each run, e.g. failover of once book.
+		src, filter := upload.Src, upload.Filter
 
 		book := Book{
-			run:   RemoteTarCommand(upload.Dst),
-			input: uploadTarReader,
-			tty:   false,
+			env: env,
+			run: RemoteTarCommand(upload.Dst),
+			openInput: func() (io.Reader, error) {
+				uploadTarReader, uploadTarErr := NewTarStreamReader(cwd, uploadFile, filter)
+				if uploadTarErr != nil {
+					return nil, errors.Wrap(uploadTarErr, "create tar stream of local path failed: "+src)
+				}
+
+				return uploadTarReader, nil
+			},
+			tty: false,
+			upload: &Upload{
+				Src:    uploadFile,
+				Dst:    upload.Dst,
+				Filter: filter,
+			},
 		}
 
 		books = append(books, &book)
@@ -141,14 +262,50 @@ func (play *Play) createUploadBooks(clients []Client, uploads map[string]Upload,
 	return
 }
 
-func (play *Play) createShellBooks(clients []Client, shell, env string, stdin bool) (books []*Book, err error) {
+func (play *Play) createShellBooks(clients []Client, shell string, envs EnvVars, template, stdin bool) (books []*Book, err error) {
 	book := Book{
-		run: shell,
-		tty: true,
+		env:    envs.AsExport(),
+		run:    shell,
+		source: shell,
+		tty:    true,
 	}
 	if stdin {
 		book.input = os.Stdin
 	}
+
+	// Render templated shell for each client, shell of command without
+	// template is run as is, e.g. docker ps --format '{{.ID}}'.
+	if template && IsTemplate(shell) {
+		if referencesFacts(shell) {
+			err = play.gatherFacts(clients)
+			if err != nil {
+				return
+			}
+		}
+
+		book.runs = make(map[Client]string, len(clients))
+
+		for _, client := range clients {
+			hostCtx, hostErr := play.hostContext(client)
+			if hostErr != nil {
+				err = errors.Wrapf(hostErr, "%sresolving host context failed", client.Prompt())
+				return
+			}
+
+			run, renderErr := hostCtx.Render(shell, envs)
+			if renderErr != nil {
+				err = errors.Wrapf(renderErr, "%srendering book failed", client.Prompt())
+				return
+			}
+
+			if play.debug {
+				run = "set -x;" + run
+			}
+
+			book.runs[client] = run
+		}
+	}
+
 	if play.debug {
 		book.run = "set -x;" + book.run
 	}
@@ -157,3 +314,16 @@ func (play *Play) createShellBooks(clients []Client, shell, env string, stdin bo
 
 	return
 }
+
+// shuffleClients returns a copy of clients in random order.
+func shuffleClients(clients []Client) []Client {
+	shuffled := make([]Client, len(clients))
+
+	randomMux.Lock()
+	for i, j := range random.Perm(len(clients)) {
+		shuffled[i] = clients[j]
+	}
+	randomMux.Unlock()
+
+	return shuffled
+}
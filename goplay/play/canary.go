@@ -0,0 +1,216 @@
+package play
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Canary defines hosts running command or book first, the rest of hosts are
+// run only if the canary is promoted. It's promoted by check run locally if
+// given, or confirmed interactively on the terminal otherwise.
+//
+// It's given by number or percentage of hosts, e.g. "canary: 1", or with
+// check, e.g. "canary: {hosts: 10%, check: ./check.sh}".
+type Canary struct {
+	Hosts BatchSize
+	Check string // Command(s) run locally, the canary is promoted if it succeeds.
+}
+
+// ParseCanary returns canary of hosts given by number or percentage, e.g. "1"
+// and "10%", with check given. It returns nil if hosts is empty.
+func ParseCanary(hosts, check string) (*Canary, error) {
+	if hosts == "" {
+		if check != "" {
+			return nil, errors.New("invalid canary, hosts is required")
+		}
+
+		return nil, nil
+	}
+
+	size, err := parseBatchSize(hosts)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid canary")
+	}
+
+	return &Canary{
+		Hosts: size,
+		Check: check,
+	}, nil
+}
+
+func (c *Canary) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	hosts, check := value, ""
+	if _, ok := value.(map[interface{}]interface{}); ok {
+		var def struct {
+			Hosts interface{} `yaml:"hosts"`
+			Check string      `yaml:"check"`
+		}
+
+		err = unmarshal(&def)
+		if err != nil {
+			return err
+		}
+
+		hosts, check = def.Hosts, def.Check
+	}
+	if hosts == nil {
+		return errors.New("invalid canary, hosts is required")
+	}
+
+	size, err := parseBatchSize(fmt.Sprint(hosts))
+	if err != nil {
+		return errors.Wrap(err, "invalid canary")
+	}
+
+	c.Hosts = size
+	c.Check = check
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, Canary without check is marshaled as
+// number or percentage of hosts.
+func (c Canary) MarshalYAML() (interface{}, error) {
+	var hosts interface{} = c.Hosts.Size
+	if c.Hosts.Percent {
+		hosts = c.Hosts.String()
+	}
+
+	if c.Check == "" {
+		return hosts, nil
+	}
+
+	return yaml.MapSlice{
+		{Key: "hosts", Value: hosts},
+		{Key: "check", Value: c.Check},
+	}, nil
+}
+
+// split returns clients of canary and the rest of them.
+func (c *Canary) split(clients []Client) (canary, rest []Client) {
+	n := c.Hosts.of(len(clients))
+	if n > len(clients) {
+		n = len(clients)
+	}
+
+	return clients[:n], clients[n:]
+}
+
+// ErrCanaryDeclined defines error of run aborted by canary declined
+type ErrCanaryDeclined struct {
+	Name  string
+	Hosts []string // Hosts touched by the canary.
+}
+
+func (e ErrCanaryDeclined) Error() string {
+	return fmt.Sprintf("Canary of %s declined, touched host(s): %s", e.Name, strings.Join(e.Hosts, ", "))
+}
+
+// confirm asks question on the terminal, and returns true if it's answered
+// with yes.
+var confirm = func(question string) (bool, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false, errors.Wrap(err, "opening terminal failed")
+	}
+	defer tty.Close()
+
+	fmt.Fprint(os.Stderr, question)
+
+	answer, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// promoteCanary returns true if canary of name given, which ran on clients, is
+// promoted to the rest of hosts. The run is aborted if it's declined. Env vars
+// of env given are exported to check of the canary with PLAY_CANARY_HOSTS.
+func (play *Play) promoteCanary(ctx context.Context, name string, canary *Canary, clients []Client, rest int, env string, secrets []string) bool {
+	hosts := make([]string, len(clients))
+	for i, client := range clients {
+		hosts[i] = play.hostName(client)
+	}
+
+	var promoted bool
+	if canary.Check != "" {
+		Infof("Canary of %s ran on %s, checking for promotion\n", name, strings.Join(hosts, ", "))
+
+		local := NewLocalClient(env)
+		local.Connect("localhost")
+
+		book := &Book{
+			clients: []Client{local},
+			env:     `export PLAY_CANARY_HOSTS="` + strings.Join(hosts, " ") + `";`,
+			run:     canary.Check,
+		}
+
+		result := play.executeBook(ctx, book, secrets)
+		promoted = result.Hosts[0].Status == StatusOK
+	} else {
+		question := fmt.Sprintf("Canary of %s ran on %s, promote to the rest %d host(s)? [y/N] ", name, strings.Join(hosts, ", "), rest)
+
+		// NOTE: reading terminal can't be interrupted, stop waiting if cancelled.
+		type answer struct {
+			yes bool
+			err error
+		}
+
+		answered := make(chan answer, 1)
+		go func() {
+			yes, err := confirm(question)
+
+			answered <- answer{yes, err}
+		}()
+
+		select {
+		case <-ctx.Done():
+			return false
+
+		case a := <-answered:
+			promoted = a.yes
+
+			if a.err != nil {
+				Errorf("%v\n", errors.Wrap(a.err, "confirming canary failed"))
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if !promoted {
+		play.failures.declined = &ErrCanaryDeclined{
+			Name:  name,
+			Hosts: hosts,
+		}
+		play.failures.aborted = fmt.Sprintf("canary of %s declined", name)
+
+		return false
+	}
+
+	Infof("Canary of %s promoted to the rest %d host(s)\n", name, rest)
+
+	return true
+}
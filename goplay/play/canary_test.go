@@ -0,0 +1,80 @@
+package play
+
+import (
+	"testing"
+
+	"github.com/golib/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_ParseCanary(t *testing.T) {
+	assertion := assert.New(t)
+
+	canary, err := ParseCanary("", "")
+	assertion.Nil(err)
+	assertion.Nil(canary)
+
+	canary, err = ParseCanary("10%", "./check.sh")
+	assertion.Nil(err)
+	assertion.Equal(&Canary{Hosts: BatchSize{Size: 10, Percent: true}, Check: "./check.sh"}, canary)
+
+	_, err = ParseCanary("", "./check.sh")
+	assertion.NotNil(err)
+
+	_, err = ParseCanary("0", "")
+	assertion.NotNil(err)
+}
+
+func Test_CanaryYAML(t *testing.T) {
+	assertion := assert.New(t)
+
+	var cmd Command
+
+	err := yaml.Unmarshal([]byte("canary: 1"), &cmd)
+	assertion.Nil(err)
+	assertion.Equal(&Canary{Hosts: BatchSize{Size: 1}}, cmd.Canary)
+
+	data, err := yaml.Marshal(cmd)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "canary: 1\n")
+
+	err = yaml.Unmarshal([]byte("canary: {hosts: 10%, check: ./check.sh}"), &cmd)
+	assertion.Nil(err)
+	assertion.Equal(&Canary{Hosts: BatchSize{Size: 10, Percent: true}, Check: "./check.sh"}, cmd.Canary)
+
+	data, err = yaml.Marshal(cmd)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "canary:\n  hosts: 10%\n  check: ./check.sh\n")
+
+	err = yaml.Unmarshal([]byte("canary: {check: ./check.sh}"), &cmd)
+	assertion.NotNil(err)
+
+	err = yaml.Unmarshal([]byte("canary: -1"), &cmd)
+	assertion.NotNil(err)
+}
+
+func Test_BooksWithCanary(t *testing.T) {
+	assertion := assert.New(t)
+
+	var pfile Playfile
+
+	err := yaml.Unmarshal([]byte(`
+books:
+  build: [compile, test]
+  deploy:
+    commands: [upload, restart]
+    canary: {hosts: 1, check: ./check.sh}
+`), &pfile)
+	assertion.Nil(err)
+	assertion.Equal([]string{"build", "deploy"}, pfile.Books.Names)
+	assertion.Nil(pfile.Books.Canary("build"))
+	assertion.Equal(&Canary{Hosts: BatchSize{Size: 1}, Check: "./check.sh"}, pfile.Books.Canary("deploy"))
+
+	book, ok := pfile.Books.Get("deploy")
+	assertion.True(ok)
+	assertion.Equal([]string{"upload", "restart"}, book)
+
+	data, err := yaml.Marshal(pfile.Books)
+	assertion.Nil(err)
+	assertion.Equal("build:\n- compile\n- test\ndeploy:\n  commands:\n  - upload\n  - restart\n  canary:\n    hosts: 1\n    check: ./check.sh\n", string(data))
+}
@@ -0,0 +1,406 @@
+package play
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tarFile is a regular file or symlink read from TAR stream.
+type tarFile struct {
+	name string
+	mode int64
+	link string // Target of symlink, it's empty for regular file.
+	sum  [sha256.Size]byte
+	data []byte
+}
+
+// readTarFiles reads regular files and symlinks of TAR stream r in order.
+func readTarFiles(r io.Reader) (files []*tarFile, err error) {
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		file := &tarFile{
+			name: strings.TrimPrefix(header.Name, "./"),
+			mode: header.Mode & 07777,
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			file.data, err = ioutil.ReadAll(reader)
+			if err != nil {
+				return nil, err
+			}
+
+			file.sum = sha256.Sum256(file.data)
+
+		case tar.TypeSymlink:
+			file.link = header.Linkname
+
+		default:
+			continue
+		}
+
+		files = append(files, file)
+	}
+}
+
+// diffTarFiles returns diff of remote files in dir against local ones, and
+// number of files changed. Files missing from remote are new files.
+func diffTarFiles(dir string, remote, local []*tarFile) (diff string, changed int) {
+	remotes := make(map[string]*tarFile, len(remote))
+	for _, file := range remote {
+		remotes[file.name] = file
+	}
+
+	var buf bytes.Buffer
+	for _, file := range local {
+		name := path.Join(dir, file.name)
+
+		old, ok := remotes[file.name]
+		if !ok {
+			changed++
+
+			fmt.Fprintf(&buf, "diff %s\nnew file mode %04o\n", name, file.mode)
+			if file.link != "" {
+				fmt.Fprintf(&buf, "symlink to %s\n", file.link)
+			} else {
+				buf.WriteString(unifiedDiff("/dev/null", name, nil, file.data))
+			}
+
+			continue
+		}
+
+		if !tarFileChanged(old, file) {
+			continue
+		}
+
+		modeChanged := old.mode != file.mode
+		linkChanged := old.link != file.link
+		dataChanged := len(old.data) != len(file.data) || old.sum != file.sum
+
+		changed++
+
+		fmt.Fprintf(&buf, "diff %s\n", name)
+		if modeChanged {
+			fmt.Fprintf(&buf, "old mode %04o\nnew mode %04o\n", old.mode, file.mode)
+		}
+
+		switch {
+		case linkChanged:
+			fmt.Fprintf(&buf, "old symlink to %s\nnew symlink to %s\n", old.link, file.link)
+
+		case dataChanged:
+			buf.WriteString(unifiedDiff(name, name, old.data, file.data))
+		}
+	}
+
+	return buf.String(), changed
+}
+
+// changedTarFiles returns number of local files differ from remote ones by
+// checksum, mode or size, it's cheaper than diffTarFiles for changes only.
+func changedTarFiles(remote, local []*tarFile) (changed int) {
+	remotes := make(map[string]*tarFile, len(remote))
+	for _, file := range remote {
+		remotes[file.name] = file
+	}
+
+	for _, file := range local {
+		old, ok := remotes[file.name]
+		if !ok || tarFileChanged(old, file) {
+			changed++
+		}
+	}
+
+	return
+}
+
+// tarFileChanged returns true if file differs from old by checksum, mode,
+// size or target of symlink.
+func tarFileChanged(old, file *tarFile) bool {
+	return old.mode != file.mode || old.link != file.link || len(old.data) != len(file.data) || old.sum != file.sum
+}
+
+// checkUploads compares local files of upload books against their remote
+// destination on each host, and prints unified diff of checksums, modes and
+// contents without changing anything. Other books are skipped. Hosts dropped
+// for failures are skipped, see FailurePolicy. Results of upload books are
+// returned, hosts with files differ are marked as changed.
+func (play *Play) checkUploads(ctx context.Context, cmd *Command, books []*Book) (results []*BookResult) {
+	policy, maxFail := play.failurePolicy(cmd)
+
+	for _, book := range books {
+		if ctx.Err() != nil || play.failures.aborted != "" {
+			return
+		}
+		if book.upload == nil {
+			continue
+		}
+
+		// NOTE: once command uploads to the first candidate only.
+		clients := book.clients
+		if book.once {
+			clients = clients[:1]
+		}
+		clients, skipped := play.skipDropped(clients)
+
+		result := &BookResult{
+			Run:   fmt.Sprintf("check upload %s -> %s", book.upload.Src, book.upload.Dst),
+			Hosts: make([]*HostResult, len(clients)),
+		}
+		for i, client := range clients {
+			result.Hosts[i] = &HostResult{
+				Host:   play.hostName(client),
+				Status: StatusFailed,
+				client: client,
+			}
+		}
+
+		local, err := play.readLocalFiles(book)
+		if err != nil {
+			err = errors.Wrapf(err, "reading local files of %s failed", book.upload.Src)
+
+			Errorf("%v\n", err)
+
+			now := time.Now()
+			for _, host := range result.Hosts {
+				host.ExitStatus = -1
+				host.Start = now
+				host.End = now
+				host.Err = err
+			}
+		} else {
+			play.checkUpload(ctx, book, local, result)
+		}
+
+		result.Hosts = append(result.Hosts, skipped...)
+
+		play.failures.record(result.Hosts, policy, maxFail)
+
+		results = append(results, result)
+	}
+
+	return
+}
+
+// checkUpload compares local files with remote files of book on each host of
+// result in parallel, diffs are printed in order of hosts.
+func (play *Play) checkUpload(ctx context.Context, book *Book, local []*tarFile, result *BookResult) {
+	names := tarFileNames(local)
+
+	var (
+		wg      sync.WaitGroup
+		diffs   = make([]string, len(result.Hosts))
+		changes = make([]int, len(result.Hosts))
+	)
+	for i, host := range result.Hosts {
+		wg.Add(1)
+
+		go func(i int, c Client, host *HostResult) {
+			defer wg.Done()
+
+			host.Start = time.Now()
+			host.Attempts = 1
+
+			err := play.forks.acquire(ctx)
+			if err != nil {
+				host.End = time.Now()
+				host.ExitStatus = -1
+				host.Err = err
+				return
+			}
+			defer play.forks.release()
+
+			remote, err := play.readRemoteFiles(ctx, c, book, names)
+			if err != nil {
+				host.End = time.Now()
+				host.ExitStatus = exitStatus(err)
+				host.Err = errors.Wrapf(err, "reading remote files of %s failed", book.upload.Dst)
+				return
+			}
+
+			diffs[i], changes[i] = diffTarFiles(book.upload.Dst, remote, local)
+
+			host.End = time.Now()
+			host.Status = StatusOK
+			host.Changed = changes[i] > 0
+		}(i, host.client, host)
+	}
+	wg.Wait()
+
+	for i, host := range result.Hosts {
+		c := host.client
+
+		prompt := PadStringWithTimestamp(c.Prompt(), play.promptLen)
+
+		switch {
+		case host.Err != nil:
+			host.Status = StatusFailed
+			if ctx.Err() == context.DeadlineExceeded {
+				host.Status = StatusTimedOut
+			} else if ctx.Err() == context.Canceled {
+				host.Status = StatusCancelled
+			}
+
+			Errorf("%s%v\n", prompt, host.Err)
+
+		case host.Changed:
+			for _, line := range strings.Split(strings.TrimSuffix(diffs[i], "\n"), "\n") {
+				fmt.Fprintf(os.Stdout, "%s%s\n", prompt, line)
+			}
+
+			Warnf("%s%d file(s) of %s would be changed\n", prompt, changes[i], book.upload.Dst)
+
+		default:
+			Infof("%sNo changes of %s\n", prompt, book.upload.Dst)
+		}
+	}
+}
+
+// uploadChanges returns clients of upload book whose files differ from the
+// upload by checksum, mode or size, files are compared on clients in parallel.
+// Clients failed to compare are taken as changed.
+func (play *Play) uploadChanges(ctx context.Context, book *Book) map[Client]bool {
+	changes := make(map[Client]bool, len(book.clients))
+
+	local, err := play.readLocalFiles(book)
+	if err != nil {
+		Warnf("%v\n", errors.Wrapf(err, "reading local files of %s failed", book.upload.Src))
+
+		for _, client := range book.clients {
+			changes[client] = true
+		}
+
+		return changes
+	}
+
+	names := tarFileNames(local)
+
+	var (
+		wg  sync.WaitGroup
+		mux sync.Mutex
+	)
+	for _, client := range book.clients {
+		wg.Add(1)
+
+		go func(c Client) {
+			defer wg.Done()
+
+			changed := true
+			if play.forks.acquire(ctx) == nil {
+				// NOTE: files are compared by checksums within forks, diffs
+				// are printed by check only, see checkUpload.
+				remote, err := play.readRemoteFiles(ctx, c, book, names)
+				if err == nil {
+					changed = changedTarFiles(remote, local) > 0
+				}
+
+				play.forks.release()
+
+				if err != nil {
+					Warnf("%s%v\n", PadStringWithTimestamp(c.Prompt(), play.promptLen), errors.Wrapf(err, "reading remote files of %s failed", book.upload.Dst))
+				}
+			}
+
+			mux.Lock()
+			changes[c] = changed
+			mux.Unlock()
+		}(client)
+	}
+	wg.Wait()
+
+	return changes
+}
+
+// tarFileNames returns names of files in order.
+func tarFileNames(files []*tarFile) []string {
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = file.name
+	}
+
+	return names
+}
+
+// readLocalFiles reads files of upload book from its TAR stream.
+func (play *Play) readLocalFiles(book *Book) ([]*tarFile, error) {
+	input, err := book.openInput()
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(input)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading gzip stream failed")
+	}
+	defer reader.Close()
+
+	return readTarFiles(reader)
+}
+
+// readRemoteFiles reads files with names given in destination of upload book
+// from client, nothing is changed on the host.
+func (play *Play) readRemoteFiles(ctx context.Context, c Client, book *Book, names []string) (files []*tarFile, err error) {
+	err = c.Run(&Book{
+		env: book.env,
+		run: RemoteTarCheckCommand(book.upload.Dst),
+	})
+	if err != nil {
+		return
+	}
+
+	go func() {
+		io.Copy(c.Stdin(), strings.NewReader(strings.Join(names, "\n")+"\n"))
+
+		// NOTE: close STDIN only, Close() of ssh client closes its connection.
+		c.Stdin().Close()
+	}()
+	err = play.waitBook(ctx, c, func() error {
+		var (
+			wg      sync.WaitGroup
+			readErr error
+		)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			io.Copy(ioutil.Discard, c.Stderr())
+		}()
+
+		files, readErr = readTarFiles(c.Stdout())
+
+		// NOTE: drain STDOUT for client to exit, e.g. padding of TAR stream.
+		io.Copy(ioutil.Discard, c.Stdout())
+		wg.Wait()
+
+		waitErr := c.Wait()
+		if waitErr != nil {
+			return waitErr
+		}
+
+		return readErr
+	})
+
+	return
+}
@@ -0,0 +1,33 @@
+package play
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_DiffTarFiles(t *testing.T) {
+	assertion := assert.New(t)
+
+	local := []*tarFile{
+		{name: "app/app.conf", mode: 0600, data: []byte("port: 8081\n")},
+		{name: "app/new.conf", mode: 0644, data: []byte("new\n")},
+		{name: "app/same.conf", mode: 0644, data: []byte("same\n")},
+	}
+	remote := []*tarFile{
+		{name: "app/app.conf", mode: 0644, data: []byte("port: 8080\n")},
+		{name: "app/same.conf", mode: 0644, data: []byte("same\n")},
+	}
+	for _, file := range append(local, remote...) {
+		file.sum = sha256.Sum256(file.data)
+	}
+
+	assertion.Equal(2, changedTarFiles(remote, local))
+	assertion.Equal(0, changedTarFiles(local, local))
+
+	diff, changed := diffTarFiles("/srv", remote, local)
+	assertion.Equal(2, changed)
+	assertion.Equal("diff /srv/app/app.conf\nold mode 0644\nnew mode 0600\n--- /srv/app/app.conf\n+++ /srv/app/app.conf\n@@ -1,1 +1,1 @@\n-port: 8080\n+port: 8081\n"+
+		"diff /srv/app/new.conf\nnew file mode 0644\n--- /dev/null\n+++ /srv/app/new.conf\n@@ -0,0 +1,1 @@\n+new\n", diff)
+}
@@ -0,0 +1,162 @@
+package play
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// diffContext is the number of unchanged lines around changes of diff.
+	diffContext = 3
+
+	// maxDiffCells bounds the table of diffing lines, files with more lines
+	// are reported as different without diff.
+	maxDiffCells = 1 << 22
+)
+
+// diffOp is an operation of line diff, kind is one of ' ', '-' and '+'.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns diff of a and b in unified format with names given.
+func unifiedDiff(aName, bName string, a, b []byte) string {
+	var buf bytes.Buffer
+
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		fmt.Fprintf(&buf, "Binary files %s and %s differ\n", aName, bName)
+
+		return buf.String()
+	}
+
+	aLines, bLines := splitLines(a), splitLines(b)
+	if len(aLines)*len(bLines) > maxDiffCells {
+		fmt.Fprintf(&buf, "Files %s and %s differ, too large to diff\n", aName, bName)
+
+		return buf.String()
+	}
+
+	ops := diffLines(aLines, bLines)
+
+	// Lines of a and b before each op, for headers of hunks.
+	aPos, bPos := make([]int, len(ops)+1), make([]int, len(ops)+1)
+	for i, op := range ops {
+		aPos[i+1], bPos[i+1] = aPos[i], bPos[i]
+		if op.kind != '+' {
+			aPos[i+1]++
+		}
+		if op.kind != '-' {
+			bPos[i+1]++
+		}
+	}
+
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", aName, bName)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// Changes with less than 2*diffContext lines between are in the same hunk.
+		last := i
+		for j := i + 1; j < len(ops); j++ {
+			if ops[j].kind == ' ' {
+				continue
+			}
+			if j-last-1 > 2*diffContext {
+				break
+			}
+
+			last = j
+		}
+
+		lo, hi := i-diffContext, last+diffContext+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(ops) {
+			hi = len(ops)
+		}
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(aPos[lo], aPos[hi]), hunkRange(bPos[lo], bPos[hi]))
+		for _, op := range ops[lo:hi] {
+			fmt.Fprintf(&buf, "%c%s\n", op.kind, op.line)
+		}
+
+		i = hi
+	}
+
+	return buf.String()
+}
+
+// diffLines returns ops turning a into b by longest common subsequence.
+func diffLines(a, b []string) (ops []diffOp) {
+	n, m := len(a), len(b)
+
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return
+}
+
+// hunkRange returns range of lines in header of hunk, start is the line
+// before hunk if it's empty.
+func hunkRange(start, end int) string {
+	if end == start {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// splitLines splits data into lines without line breaks.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
@@ -0,0 +1,22 @@
+package play
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_UnifiedDiff(t *testing.T) {
+	assertion := assert.New(t)
+
+	assertion.Equal("--- a\n+++ b\n@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n", unifiedDiff("a", "b", []byte("a\nb\nc\n"), []byte("a\nB\nc\nd\n")))
+	assertion.Equal("--- /dev/null\n+++ b\n@@ -0,0 +1,1 @@\n+a\n", unifiedDiff("/dev/null", "b", nil, []byte("a\n")))
+	assertion.Equal("Binary files a and b differ\n", unifiedDiff("a", "b", []byte("a\x00"), []byte("b")))
+
+	// changes far from each other are in separated hunks
+	lines := strings.Repeat("x\n", 10)
+
+	diff := unifiedDiff("a", "b", []byte("a\n"+lines+"b\n"), []byte("A\n"+lines+"B\n"))
+	assertion.Equal("--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n x\n x\n x\n@@ -9,4 +9,4 @@\n x\n x\n x\n-b\n+B\n", diff)
+}
@@ -0,0 +1,184 @@
+package play
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	envKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// EnvFiles is a list of dotenv-style files, it maps to a YAML string or list.
+type EnvFiles []string
+
+func (f *EnvFiles) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var file string
+
+	err := unmarshal(&file)
+	if err == nil {
+		*f = EnvFiles{file}
+		return nil
+	}
+
+	var files []string
+
+	err = unmarshal(&files)
+	if err != nil {
+		return err
+	}
+
+	*f = EnvFiles(files)
+	return nil
+}
+
+// LoadEnvFile returns env vars parsed from dotenv-style file given.
+func LoadEnvFile(filename string) (EnvVars, error) {
+	data, err := ioutil.ReadFile(path.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseEnvFile(data)
+}
+
+// ParseEnvFile parses dotenv-style data and returns env vars in order.
+// It supports:
+//
+//	# comments, and empty lines
+//	export KEY=value
+//	KEY=value # inline comment
+//	KEY='single quoted, used as is'
+//	KEY="double quoted, with escapes of \n, \t, \r, \", \\ and \$"
+//	KEY="multi-line
+//	value"
+//
+// All values are marked as literal, they are used as is without bash resolving.
+func ParseEnvFile(data []byte) (envs EnvVars, err error) {
+	lines := strings.Split(strings.Replace(string(data), "\r\n", "\n", -1), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineno := i + 1
+
+		line := strings.TrimLeft(lines[i], " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "export ") || strings.HasPrefix(line, "export\t") {
+			line = strings.TrimLeft(line[len("export"):], " \t")
+		}
+
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			err = fmt.Errorf("line %d: missing = of env var", lineno)
+			return
+		}
+
+		key := strings.TrimSpace(line[:eq])
+		if !envKey.MatchString(key) {
+			err = fmt.Errorf("line %d: invalid key %q of env var", lineno, key)
+			return
+		}
+
+		value := strings.TrimLeft(line[eq+1:], " \t")
+
+		switch {
+		case strings.HasPrefix(value, `'`), strings.HasPrefix(value, `"`):
+			quote := value[0]
+			value = value[1:]
+
+			for {
+				end := closingQuoteIndex(value, quote)
+				if end >= 0 {
+					rest := strings.TrimSpace(value[end+1:])
+					if rest != "" && !strings.HasPrefix(rest, "#") {
+						err = fmt.Errorf("line %d: unexpected %q after quoted value of %s", i+1, rest, key)
+						return
+					}
+
+					value = value[:end]
+					break
+				}
+
+				// multi-line value
+				i++
+				if i >= len(lines) {
+					err = fmt.Errorf("line %d: unterminated quoted value of %s", lineno, key)
+					return
+				}
+
+				value += "\n" + lines[i]
+			}
+
+			if quote == '"' {
+				value = unescapeEnvValue(value)
+			}
+
+		default:
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = value[:comment]
+			}
+
+			value = strings.TrimSpace(value)
+		}
+
+		envs.setVar(EnvVar{
+			Key:     key,
+			Value:   value,
+			Literal: true,
+		})
+	}
+
+	return
+}
+
+// closingQuoteIndex returns index of the closing quote, or -1 if not found.
+// Backslash escapes double quote only.
+func closingQuoteIndex(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+
+		case quote:
+			return i
+		}
+	}
+
+	return -1
+}
+
+func unescapeEnvValue(s string) string {
+	var buf bytes.Buffer
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			buf.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 't':
+			buf.WriteByte('\t')
+		case 'r':
+			buf.WriteByte('\r')
+		case '"', '\\', '$':
+			buf.WriteByte(s[i])
+		default:
+			buf.WriteByte('\\')
+			buf.WriteByte(s[i])
+		}
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,95 @@
+package play
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_ParseEnvFile(t *testing.T) {
+	assertion := assert.New(t)
+
+	envs, err := ParseEnvFile([]byte(`# comment
+APP=goplay
+export ENV = production # inline comment
+
+SINGLE='single $NOT "resolved"'
+DOUBLE="tab\tquote\"dollar\$ # not comment"
+MULTI="line 1
+  line 2"
+URL=http://example.com/#anchor
+EMPTY=
+APP=override
+`))
+	assertion.Nil(err)
+	assertion.Equal([]string{
+		"APP=override",
+		"ENV=production",
+		`SINGLE=single $NOT "resolved"`,
+		"DOUBLE=tab\tquote\"dollar$ # not comment",
+		"MULTI=line 1\n  line 2",
+		"URL=http://example.com/#anchor",
+		"EMPTY=",
+	}, envs.Slice())
+
+	for _, env := range envs {
+		assertion.True(env.Literal)
+	}
+
+	_, err = ParseEnvFile([]byte("INVALID"))
+	assertion.NotNil(err)
+
+	_, err = ParseEnvFile([]byte("1KEY=value"))
+	assertion.NotNil(err)
+
+	_, err = ParseEnvFile([]byte(`KEY="unterminated`))
+	assertion.NotNil(err)
+}
+
+func Test_PlayfileEnvFile(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	assertion.Nil(ioutil.WriteFile(filepath.Join(dir, "global.env"), []byte("NAME=goplay\nPASS='pa$$'\nSERVICE=app\n"), 0600))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(dir, "network.env"), []byte("SERVICE=api\nREGION=cn\n"), 0600))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(dir, "Playfile.yml"), []byte(`---
+version: 1.0.0
+
+env_file: global.env
+envs:
+  SERVICE: $NAME-$PASS
+
+networks:
+  all:
+    env_file:
+      - network.env
+    env:
+      REGION: us
+    hosts:
+      - 127.0.0.1
+`), 0600))
+
+	pfile, err := NewPlayfileFromFile(filepath.Join(dir, "Playfile.yml"))
+	assertion.Nil(err)
+
+	envs, err := pfile.EnvsFor(nil, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal([]string{"NAME=goplay", "PASS=pa$$", "SERVICE=goplay-pa$$"}, envs.Slice())
+
+	network, _ := pfile.Networks.Get("all")
+
+	envs, err = pfile.EnvsFor(&network, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal([]string{"NAME=goplay", "PASS=pa$$", "SERVICE=api", "REGION=us"}, envs.Slice())
+
+	network.EnvFile = EnvFiles{"missing.env"}
+
+	_, err = pfile.EnvsFor(&network, nil, nil)
+	assertion.NotNil(err)
+}
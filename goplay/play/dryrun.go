@@ -0,0 +1,337 @@
+package play
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// planClient is a placeholder of host for dry run, it's never connected.
+type planClient struct {
+	prompt string
+}
+
+func (c *planClient) Connect(_ string) error {
+	return ErrDryRun
+}
+
+func (c *planClient) Run(_ *Book) error {
+	return ErrDryRun
+}
+
+func (c *planClient) Wait() error {
+	return ErrDryRun
+}
+
+func (c *planClient) Write(_ []byte) (int, error) {
+	return 0, ErrDryRun
+}
+
+func (c *planClient) Close() error {
+	return nil
+}
+
+func (c *planClient) Stdin() io.WriteCloser {
+	return nil
+}
+
+func (c *planClient) Stderr() io.Reader {
+	return nil
+}
+
+func (c *planClient) Stdout() io.Reader {
+	return nil
+}
+
+func (c *planClient) Signal(_ os.Signal) error {
+	return ErrDryRun
+}
+
+func (c *planClient) Prompt() string {
+	return c.prompt
+}
+
+func (c *planClient) LastError() error {
+	return nil
+}
+
+// printPlan prints plan of commands on network to w without connecting to
+// any of hosts. Books of each command are created as they would be run, the
+// plan shows env vars exported, batches and hosts of books, uploads and
+// rendered command(s) for each host. Secrets are masked.
+func (play *Play) printPlan(w io.Writer, network *Network, envs EnvVars, commands ...*Command) error {
+	networkEnvs, err := play.config.EnvsFor(network, nil, envs)
+	if err != nil {
+		return errors.Wrap(err, "resolving env vars failed")
+	}
+
+	play.network = network
+	play.hosts = make(map[Client]*HostContext, len(network.Hosts))
+
+	clients := make([]Client, len(network.Hosts))
+	for i, host := range network.Hosts {
+		hostCtx, err := NewHostContext(network, i, host)
+		if err != nil {
+			return errors.Wrapf(err, "resolving host context of %s failed", MaskUserHostWithPasswd(host))
+		}
+
+		clients[i] = &planClient{
+			prompt: fmt.Sprintf("[%s@%s] ", hostCtx.User, hostCtx.Host),
+		}
+
+		play.hosts[clients[i]] = hostCtx
+	}
+
+	fmt.Fprintf(w, "# Plan of network %s with %d host(s)\n", network.Name, len(clients))
+
+	if len(networkEnvs) > 0 {
+		secrets := networkEnvs.Secrets()
+
+		fmt.Fprintf(w, "# env:\n")
+		for _, v := range networkEnvs {
+			fmt.Fprintf(w, "#   %s\n", MaskSecrets(v.AsExport(), secrets))
+		}
+	}
+
+	if play.lock != nil {
+		if play.lock.Network {
+			fmt.Fprintf(w, "# Lock %s on all hosts, nothing runs if any host is locked by others\n", LockFile(play.lock.Name))
+		} else {
+			fmt.Fprintf(w, "# Lock %s on each host, hosts locked by others are skipped\n", LockFile(play.lock.Name))
+		}
+	}
+
+	if play.canary == nil {
+		return play.printCommands(w, network, clients, commands, envs, networkEnvs)
+	}
+
+	canary, rest := play.canary.split(clients)
+
+	fmt.Fprintf(w, "# Canary of run: %s\n", play.hostNames(canary))
+
+	err = play.printCommands(w, network, canary, commands, envs, networkEnvs)
+	if err != nil || len(rest) == 0 {
+		return err
+	}
+
+	if play.canary.Check != "" {
+		fmt.Fprintf(w, "# Promote canary of run if check succeeds: %s\n", play.canary.Check)
+	} else {
+		fmt.Fprintf(w, "# Promote canary of run if confirmed on the terminal\n")
+	}
+	fmt.Fprintf(w, "# Rest of run: %s\n", play.hostNames(rest))
+
+	return play.printCommands(w, network, rest, commands, envs, networkEnvs)
+}
+
+// printCommands prints books of commands planned for clients to w, and books
+// of handlers they may notify.
+func (play *Play) printCommands(w io.Writer, network *Network, clients []Client, commands []*Command, envs, networkEnvs EnvVars) error {
+	notified := make(map[string]bool)
+
+	for _, cmd := range commands {
+		err := play.printCommand(w, network, clients, cmd, envs, networkEnvs, "")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range cmd.Notify {
+			notified[name] = true
+		}
+	}
+
+	if play.config == nil {
+		return nil
+	}
+
+	for _, name := range play.config.Handlers.Names {
+		if !notified[name] {
+			continue
+		}
+
+		handler, _ := play.config.Handlers.Get(name)
+		handler.Name = name
+
+		err := play.printCommand(w, network, clients, &handler, envs, networkEnvs, "handler ")
+		if err != nil {
+			return err
+		}
+
+		for _, name := range handler.Notify {
+			notified[name] = true
+		}
+	}
+
+	return nil
+}
+
+// printCommand prints books of cmd planned for clients to w, the header of
+// cmd is prefixed with kind given, e.g. "handler ".
+func (play *Play) printCommand(w io.Writer, network *Network, clients []Client, cmd *Command, envs, networkEnvs EnvVars, kind string) error {
+	books, cmdEnvs, err := play.plan(network, clients, cmd, envs, networkEnvs)
+	if err != nil {
+		return err
+	}
+
+	secrets := cmdEnvs.Secrets()
+
+	fmt.Fprintln(w)
+	if cmd.Desc != "" {
+		fmt.Fprintf(w, "# %s%s: %s\n", kind, cmd.Name, cmd.Desc)
+	} else {
+		fmt.Fprintf(w, "# %s%s\n", kind, cmd.Name)
+	}
+	if kind != "" {
+		fmt.Fprintf(w, "# run once on hosts notified only\n")
+	}
+
+	if options := commandOptions(cmd); len(options) > 0 {
+		fmt.Fprintf(w, "# %s\n", strings.Join(options, ", "))
+	}
+
+	if cmd.Template && (referencesFacts(cmd.Run) || referencesFacts(cmd.HealthCheck)) {
+		fmt.Fprintf(w, "# facts are not gathered in dry run mode\n")
+	}
+
+	// NOTE: env vars of network are printed once for all commands.
+	if envs := cmdEnvs.diff(networkEnvs); len(envs) > 0 {
+		fmt.Fprintf(w, "# env:\n")
+		for _, v := range envs {
+			fmt.Fprintf(w, "#   %s\n", MaskSecrets(v.AsExport(), secrets))
+		}
+	}
+
+	play.printBooks(w, cmd, books, secrets)
+
+	return nil
+}
+
+// printBooks prints books of cmd in order of running, grouped by batch.
+func (play *Play) printBooks(w io.Writer, cmd *Command, books []*Book, secrets []string) {
+	batches := 0
+	for i, book := range books {
+		if i == 0 || book.batch != books[i-1].batch {
+			batches++
+		}
+	}
+
+	for i, book := range books {
+		clients := book.clients
+
+		switch {
+		case book.once:
+			// NOTE: books are rendered for the first candidate, it fails over to next ones.
+			if i == 0 {
+				fmt.Fprintf(w, "## once %s on the first of: %s\n", cmd.Once, play.hostNames(clients))
+			}
+
+			clients = clients[:1]
+
+		case i == 0 || book.batch != books[i-1].batch:
+			switch {
+			case i > 0 && books[i-1].canary && !book.canary:
+				if cmd.Canary.Check != "" {
+					fmt.Fprintf(w, "## promote canary if check succeeds: %s\n", cmd.Canary.Check)
+				} else {
+					fmt.Fprintf(w, "## promote canary if confirmed on the terminal\n")
+				}
+
+			case i > 0 && cmd.PauseBetweenBatches > 0:
+				fmt.Fprintf(w, "## pause %v\n", cmd.PauseBetweenBatches)
+			}
+
+			var label string
+			if book.canary {
+				label = " (canary)"
+			}
+
+			fmt.Fprintf(w, "## batch %d/%d%s: %s\n", book.batch+1, batches, label, play.hostNames(clients))
+		}
+
+		for _, client := range clients {
+			prompt := client.Prompt() + ">>> "
+
+			if book.upload != nil {
+				fmt.Fprintf(w, "%supload %s -> %s", prompt, book.upload.Src, book.upload.Dst)
+				if book.upload.Filter != "" {
+					fmt.Fprintf(w, " (filter %s)", book.upload.Filter)
+				}
+				fmt.Fprintln(w)
+
+				continue
+			}
+
+			if book.healthCheck {
+				prompt += "health_check: "
+			}
+
+			run := MaskSecrets(book.script(client), secrets)
+			for _, line := range strings.Split(strings.TrimRight(run, "\n"), "\n") {
+				fmt.Fprintf(w, "%s%s\n", prompt, line)
+			}
+		}
+	}
+}
+
+// hostNames returns names of clients joined with comma.
+func (play *Play) hostNames(clients []Client) string {
+	names := make([]string, len(clients))
+	for i, client := range clients {
+		names[i] = play.hostName(client)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// commandOptions returns options of cmd affecting how it's run, e.g. serial.
+func commandOptions(cmd *Command) (options []string) {
+	if cmd.Locally {
+		options = append(options, "locally")
+	}
+	if cmd.Template {
+		options = append(options, "template")
+	}
+	if cmd.Once != "" {
+		options = append(options, "once "+string(cmd.Once))
+	}
+	if len(cmd.Serial) > 0 {
+		options = append(options, "serial "+cmd.Serial.String())
+	}
+	if cmd.Canary != nil {
+		options = append(options, "canary "+cmd.Canary.Hosts.String())
+	}
+	if cmd.Timeout > 0 {
+		options = append(options, fmt.Sprintf("timeout %v", cmd.Timeout))
+	}
+	if cmd.Retries > 0 {
+		options = append(options, fmt.Sprintf("retries %d", cmd.Retries))
+	}
+	if cmd.OnFailure != "" {
+		options = append(options, "on_failure "+string(cmd.OnFailure))
+	}
+	if cmd.MaxFailPercentage > 0 {
+		options = append(options, fmt.Sprintf("max_fail_percentage %d%%", cmd.MaxFailPercentage))
+	}
+	if cmd.ChangedExitCode > 0 {
+		options = append(options, fmt.Sprintf("changed_exit_code %d", cmd.ChangedExitCode))
+	}
+	if cmd.ChangedMarker != "" {
+		options = append(options, fmt.Sprintf("changed_marker %q", cmd.ChangedMarker))
+	}
+	if len(cmd.Notify) > 0 {
+		options = append(options, "notify "+strings.Join(cmd.Notify, " "))
+	}
+	if cmd.Rollback != "" {
+		scope := cmd.RollbackScope
+		if scope == "" {
+			scope = RollbackFailed
+		}
+
+		options = append(options, fmt.Sprintf("rollback %s hosts with %q", scope, cmd.Rollback))
+	}
+
+	return
+}
@@ -0,0 +1,60 @@
+package play
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+)
+
+func Test_PlayPrintPlan(t *testing.T) {
+	assertion := assert.New(t)
+
+	network := &Network{
+		Name:  "local",
+		Hosts: []string{"localhost", "127.0.0.1", "127.0.0.2"},
+	}
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	var buf bytes.Buffer
+
+	err = player.printPlan(&buf, network, EnvVars{{Key: "APP", Value: "web"}}, &Command{
+		Name: "migrate",
+		Run:  "echo migrate",
+		Once: OnceFirst,
+	}, &Command{
+		Name: "ps",
+		Run:  "docker ps --format '{{.ID}}'",
+		Once: OnceFirst,
+	}, &Command{
+		Name:                "deploy",
+		Desc:                "Deploy APP",
+		Uploads:             map[string]Upload{"dist": {Src: "./dist", Dst: "/srv/$APP"}},
+		Run:                 "echo deploy {{ .Host }}",
+		Template:            true,
+		Env:                 EnvVars{{Key: "PORT", Value: "8080"}},
+		Serial:              Serial{{Size: 1}},
+		PauseBetweenBatches: 10 * time.Second,
+		HealthCheck:         "curl -fsS localhost",
+		Canary:              &Canary{Hosts: BatchSize{Size: 1}, Check: "./check.sh"},
+	})
+	assertion.Nil(err)
+
+	plan := buf.String()
+	assertion.Contains(plan, "# Plan of network local with 3 host(s)\n# env:\n#   export APP=\"web\";\n\n")
+	assertion.Equal(1, strings.Count(plan, "export APP="))
+	assertion.Contains(plan, "## once first on the first of: ")
+	assertion.Contains(plan, "@localhost] >>> echo migrate\n\n# ps\n")
+	assertion.Contains(plan, "@localhost] >>> docker ps --format '{{.ID}}'\n\n# deploy: Deploy APP\n# template, serial 1, canary 1\n# env:\n#   export PORT=\"8080\";\n## batch 1/3 (canary): ")
+	assertion.Contains(plan, "## batch 1/3 (canary): ")
+	assertion.Contains(plan, "@localhost] >>> upload ./dist -> /srv/$APP\n")
+	assertion.Contains(plan, "@localhost] >>> echo deploy localhost\n")
+	assertion.Contains(plan, "@localhost] >>> health_check: curl -fsS localhost\n")
+	assertion.Contains(plan, "## promote canary if check succeeds: ./check.sh\n## batch 2/3: ")
+	assertion.Contains(plan, "## pause 10s\n## batch 3/3: ")
+	assertion.Contains(plan, "@127.0.0.2:22] >>> echo deploy 127.0.0.2:22\n")
+}
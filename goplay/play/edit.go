@@ -0,0 +1,414 @@
+package play
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// editYAML returns YAML of source edited to data, old and data are YAML of
+// source and its edited Playfile marshaled, see Playfile.Marshal. Nodes of
+// source are kept with their comments, anchors, aliases and styles unless
+// their values are changed, mappings and sequences changed are edited in
+// place, others are replaced.
+//
+// NOTE: editing values referenced by aliases, or removing values merged from
+// aliases is not supported, for it changes others referencing them too.
+func editYAML(source, old, data []byte) ([]byte, error) {
+	var src, oldDoc, newDoc yamlv3.Node
+
+	for _, doc := range []struct {
+		node *yamlv3.Node
+		data []byte
+	}{
+		{&src, source},
+		{&oldDoc, old},
+		{&newDoc, data},
+	} {
+		err := yamlv3.Unmarshal(doc.data, doc.node)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing YAML failed")
+		}
+	}
+
+	// NOTE: Playfile of empty source is written as is.
+	if len(src.Content) == 0 || len(oldDoc.Content) == 0 || len(newDoc.Content) == 0 {
+		return data, nil
+	}
+
+	editor := &yamlEditor{
+		aliased: make(map[*yamlv3.Node]bool),
+		spaced:  make(map[*yamlv3.Node]bool),
+	}
+	editor.findAliased(&src)
+	editor.findSpaced(&src, bytes.Split(source, []byte("\n")))
+
+	root, err := editor.edit(src.Content[0], oldDoc.Content[0], newDoc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+	src.Content[0] = root
+
+	untagMergeKeys(&src)
+
+	var buf bytes.Buffer
+
+	encoder := yamlv3.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent(source))
+
+	err = encoder.Encode(&src)
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return editor.space(&src, buf.Bytes())
+}
+
+// yamlEditor edits nodes of source, see editYAML.
+type yamlEditor struct {
+	aliased map[*yamlv3.Node]bool // Nodes referenced by aliases of source.
+	spaced  map[*yamlv3.Node]bool // Keys of source following blank lines.
+}
+
+// findSpaced records keys of node and its children following blank lines of
+// source, yaml.v3 drops blank lines, see space.
+func (e *yamlEditor) findSpaced(node *yamlv3.Node, lines [][]byte) {
+	if node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			n := keyLine(node.Content[i]) - 1
+			if n > 0 && n <= len(lines) && len(bytes.TrimSpace(lines[n-1])) == 0 {
+				e.spaced[node.Content[i]] = true
+			}
+		}
+	}
+	if node.Kind == yamlv3.AliasNode {
+		return
+	}
+
+	for _, child := range node.Content {
+		e.findSpaced(child, lines)
+	}
+}
+
+// space returns YAML data encoded from node with blank lines of source kept
+// before keys, see findSpaced.
+func (e *yamlEditor) space(node *yamlv3.Node, data []byte) ([]byte, error) {
+	var encoded yamlv3.Node
+
+	err := yamlv3.Unmarshal(data, &encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing YAML edited failed")
+	}
+
+	blanks := make(map[int]bool)
+	e.findBlanks(node, &encoded, blanks)
+
+	var buf bytes.Buffer
+
+	buf.WriteString("---\n")
+	for i, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if blanks[i+1] {
+			buf.WriteString("\n")
+		}
+
+		buf.Write(line)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// findBlanks records lines of encoded leading keys spaced of node, nodes are
+// of the same structure.
+func (e *yamlEditor) findBlanks(node, encoded *yamlv3.Node, blanks map[int]bool) {
+	if node.Kind != encoded.Kind || len(node.Content) != len(encoded.Content) || node.Kind == yamlv3.AliasNode {
+		return
+	}
+
+	for i, child := range node.Content {
+		// NOTE: the first key of mapping is never spaced.
+		if node.Kind == yamlv3.MappingNode && i > 0 && i%2 == 0 && e.spaced[child] {
+			blanks[keyLine(encoded.Content[i])] = true
+		}
+
+		e.findBlanks(child, encoded.Content[i], blanks)
+	}
+}
+
+// keyLine returns line of key leading by its head comment, it starts from 1.
+func keyLine(key *yamlv3.Node) int {
+	if key.HeadComment == "" {
+		return key.Line
+	}
+
+	return key.Line - strings.Count(key.HeadComment, "\n") - 1
+}
+
+// untagMergeKeys clears tag of merge keys of node and its children, yaml.v3
+// encodes them as "!!merge <<" otherwise.
+func untagMergeKeys(node *yamlv3.Node) {
+	if node.Kind == yamlv3.AliasNode {
+		return
+	}
+
+	if node.Kind == yamlv3.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Kind == yamlv3.ScalarNode && key.Value == "<<" && key.ShortTag() == "!!merge" {
+				key.Tag = ""
+			}
+		}
+	}
+
+	for _, child := range node.Content {
+		untagMergeKeys(child)
+	}
+}
+
+// findAliased records nodes referenced by aliases of node and its children.
+func (e *yamlEditor) findAliased(node *yamlv3.Node) {
+	if node.Kind == yamlv3.AliasNode {
+		e.aliased[node.Alias] = true
+		return
+	}
+
+	for _, child := range node.Content {
+		e.findAliased(child)
+	}
+}
+
+// hasAliased returns true if node or any of its children is referenced by
+// aliases.
+func (e *yamlEditor) hasAliased(node *yamlv3.Node) bool {
+	if e.aliased[node] {
+		return true
+	}
+	if node.Kind == yamlv3.AliasNode {
+		return false
+	}
+
+	for _, child := range node.Content {
+		if e.hasAliased(child) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// edit returns node of src edited from value of old to value of new.
+func (e *yamlEditor) edit(src, old, new *yamlv3.Node) (*yamlv3.Node, error) {
+	if equalYAMLNode(old, new) {
+		return src, nil
+	}
+
+	// NOTE: alias is replaced by value, the node referenced is kept.
+	if src.Kind == yamlv3.AliasNode {
+		return withComments(new, src), nil
+	}
+	if e.aliased[src] {
+		return nil, errors.Wrapf(ErrNotSupported, "editing value of &%s referenced by aliases at line %d", src.Anchor, src.Line)
+	}
+
+	switch {
+	case src.Kind == yamlv3.MappingNode && old.Kind == yamlv3.MappingNode && new.Kind == yamlv3.MappingNode:
+		return e.editMapping(src, old, new)
+
+	case src.Kind == yamlv3.SequenceNode && old.Kind == yamlv3.SequenceNode && new.Kind == yamlv3.SequenceNode && len(src.Content) == len(old.Content):
+		return e.editSequence(src, old, new)
+	}
+
+	return e.replace(src, new)
+}
+
+// editMapping returns mapping of src with items changed edited, items added
+// appended, and items removed dropped.
+func (e *yamlEditor) editMapping(src, old, new *yamlv3.Node) (*yamlv3.Node, error) {
+	olds, news := yamlMapping(old), yamlMapping(new)
+
+	node := *src
+	node.Content = nil
+
+	var (
+		merged   bool
+		explicit = make(map[string]bool)
+	)
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		if key.Kind == yamlv3.ScalarNode && key.ShortTag() == "!!merge" {
+			merged = true
+
+			node.Content = append(node.Content, key, value)
+			continue
+		}
+
+		explicit[key.Value] = true
+
+		oldValue, inOld := olds[key.Value]
+		newValue, inNew := news[key.Value]
+
+		switch {
+		case !inOld && !inNew:
+			// NOTE: items unknown to Playfile are kept as is.
+			node.Content = append(node.Content, key, value)
+
+		case !inNew:
+			if e.hasAliased(value) {
+				return nil, errors.Wrapf(ErrNotSupported, "removing %s referenced by aliases at line %d", key.Value, key.Line)
+			}
+
+		case !inOld:
+			value, err := e.replace(value, newValue)
+			if err != nil {
+				return nil, err
+			}
+
+			node.Content = append(node.Content, key, value)
+
+		default:
+			value, err := e.edit(value, oldValue, newValue)
+			if err != nil {
+				return nil, err
+			}
+
+			node.Content = append(node.Content, key, value)
+		}
+	}
+
+	// NOTE: items added are spaced as the last item of src.
+	spaced := len(src.Content) > 0 && e.spaced[src.Content[len(src.Content)-2]]
+
+	for i := 0; i+1 < len(new.Content); i += 2 {
+		key, value := new.Content[i], new.Content[i+1]
+		if explicit[key.Value] {
+			continue
+		}
+
+		// NOTE: items merged from aliases are overridden only if changed.
+		if oldValue, ok := olds[key.Value]; ok && equalYAMLNode(oldValue, value) {
+			continue
+		}
+
+		if spaced {
+			e.spaced[key] = true
+		}
+
+		node.Content = append(node.Content, key, value)
+	}
+
+	if merged {
+		for key := range olds {
+			if _, ok := news[key]; !ok && !explicit[key] {
+				return nil, errors.Wrapf(ErrNotSupported, "removing %s merged from aliases at line %d", key, src.Line)
+			}
+		}
+	}
+
+	return &node, nil
+}
+
+// editSequence returns sequence of src with items of new, items unchanged are
+// kept in order of new.
+func (e *yamlEditor) editSequence(src, old, new *yamlv3.Node) (*yamlv3.Node, error) {
+	node := *src
+	node.Content = nil
+
+	used := make([]bool, len(old.Content))
+	for _, value := range new.Content {
+		found := -1
+		for i, oldValue := range old.Content {
+			if !used[i] && equalYAMLNode(oldValue, value) {
+				found = i
+				break
+			}
+		}
+
+		if found < 0 {
+			node.Content = append(node.Content, value)
+			continue
+		}
+
+		used[found] = true
+
+		node.Content = append(node.Content, src.Content[found])
+	}
+
+	for i, ok := range used {
+		if !ok && e.hasAliased(src.Content[i]) {
+			return nil, errors.Wrapf(ErrNotSupported, "removing item referenced by aliases at line %d", src.Content[i].Line)
+		}
+	}
+
+	return &node, nil
+}
+
+// replace returns new with comments of src, src must not be referenced by
+// aliases.
+func (e *yamlEditor) replace(src, new *yamlv3.Node) (*yamlv3.Node, error) {
+	if e.hasAliased(src) {
+		return nil, errors.Wrapf(ErrNotSupported, "replacing value referenced by aliases at line %d", src.Line)
+	}
+
+	return withComments(new, src), nil
+}
+
+// withComments returns copy of node with comments of src.
+func withComments(node, src *yamlv3.Node) *yamlv3.Node {
+	copy := *node
+	copy.HeadComment = src.HeadComment
+	copy.LineComment = src.LineComment
+	copy.FootComment = src.FootComment
+
+	return &copy
+}
+
+// yamlMapping returns values of mapping node by their keys.
+func yamlMapping(node *yamlv3.Node) map[string]*yamlv3.Node {
+	values := make(map[string]*yamlv3.Node, len(node.Content)/2)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		values[node.Content[i].Value] = node.Content[i+1]
+	}
+
+	return values
+}
+
+// equalYAMLNode returns true if a and b are of the same value, nodes of YAML
+// marshaled by Playfile.Marshal are compared only.
+func equalYAMLNode(a, b *yamlv3.Node) bool {
+	if a.Kind != b.Kind || a.ShortTag() != b.ShortTag() || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+
+	for i := range a.Content {
+		if !equalYAMLNode(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// yamlIndent returns indentation of YAML data, it's width of the first line
+// indented, and defaults to 2.
+func yamlIndent(data []byte) int {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, " ")
+
+		n := len(line) - len(trimmed)
+		if n == 0 || len(bytes.TrimSpace(trimmed)) == 0 || trimmed[0] == '#' {
+			continue
+		}
+
+		if n > 8 {
+			break
+		}
+
+		return n
+	}
+
+	return 2
+}
@@ -15,6 +15,7 @@ var (
 	ErrOpened       = errors.New("Session has opened.")
 	ErrNotOpened    = errors.New("Session not opened.")
 	ErrNotSupported = errors.New("Not supported.")
+	ErrDryRun       = errors.New("Nothing is run in dry run mode.")
 )
 
 // ErrConnect defines connection error with reason
@@ -56,3 +57,12 @@ type ErrMustUpgrade struct {
 func (e ErrMustUpgrade) Error() string {
 	return fmt.Sprintf("%s\n\nPlease upgrading goplay by `go get -u github.com/dolab/goplay`", e.Msg)
 }
+
+// ErrFailures defines error of run with failures, see Result.Stats
+type ErrFailures struct {
+	Stats Stats
+}
+
+func (e ErrFailures) Error() string {
+	return fmt.Sprintf("Run failed with %d failure(s): %v", e.Stats.Failures(), e.Stats)
+}
@@ -0,0 +1,120 @@
+package play
+
+import (
+	"fmt"
+)
+
+// failureTracker tracks hosts failed during run, see FailurePolicy.
+type failureTracker struct {
+	total       int             // Number of hosts of network, including unreachable ones.
+	unreachable int             // Number of hosts failed to connect.
+	failed      map[Client]bool // Hosts failed, including ones kept by FailureContinue.
+	dropped     map[Client]bool // Hosts dropped out of later books.
+	aborted     string          // Reason of aborting the run, it's empty if not aborted.
+	declined    *ErrCanaryDeclined
+}
+
+func newFailureTracker(total, unreachable int) *failureTracker {
+	return &failureTracker{
+		total:       total,
+		unreachable: unreachable,
+		failed:      make(map[Client]bool),
+		dropped:     make(map[Client]bool),
+	}
+}
+
+// isDropped returns true if client has dropped out of later books.
+func (t *failureTracker) isDropped(client Client) bool {
+	return t.dropped[client]
+}
+
+// record records failures of hosts with policy given, and returns number of
+// them. Hosts failed are dropped unless the policy is FailureContinue, and
+// the run is aborted if the policy is FailureAbort or failed hosts exceed
+// maxFail.
+func (t *failureTracker) record(hosts []*HostResult, policy FailurePolicy, maxFail Percentage) (failures int) {
+	for _, host := range hosts {
+		switch host.Status {
+		case StatusOK, StatusFailedOver, StatusSkipped:
+			continue
+		}
+
+		failures++
+
+		if host.client == nil {
+			continue
+		}
+
+		t.failed[host.client] = true
+		if policy != FailureContinue {
+			t.dropped[host.client] = true
+		}
+	}
+
+	if t.aborted != "" || failures == 0 {
+		return
+	}
+
+	switch {
+	case policy == FailureAbort:
+		t.aborted = fmt.Sprintf("%d host(s) failed with on_failure %s", failures, policy)
+
+	case t.exceeds(maxFail):
+		t.aborted = fmt.Sprintf("%d of %d host(s) failed, exceeding max_fail_percentage %d%%", t.unreachable+len(t.failed), t.total, maxFail)
+	}
+
+	return
+}
+
+// exceeds returns true if percentage of failed hosts exceeds maxFail, it's
+// always false if maxFail is 0.
+func (t *failureTracker) exceeds(maxFail Percentage) bool {
+	if maxFail <= 0 || t.total == 0 {
+		return false
+	}
+
+	return (t.unreachable+len(t.failed))*100 > int(maxFail)*t.total
+}
+
+// failurePolicy returns failure policy and max fail percentage of cmd, which
+// default to Playfile's.
+func (play *Play) failurePolicy(cmd *Command) (policy FailurePolicy, maxFail Percentage) {
+	policy = FailureSkipHost
+	if play.config != nil {
+		if play.config.OnFailure != "" {
+			policy = play.config.OnFailure
+		}
+
+		maxFail = play.config.MaxFailPercentage
+	}
+
+	if cmd != nil {
+		if cmd.OnFailure != "" {
+			policy = cmd.OnFailure
+		}
+
+		if cmd.MaxFailPercentage > 0 {
+			maxFail = cmd.MaxFailPercentage
+		}
+	}
+
+	return
+}
+
+// skipDropped returns clients not dropped, and results of clients skipped.
+func (play *Play) skipDropped(clients []Client) (active []Client, skipped []*HostResult) {
+	for _, client := range clients {
+		if !play.failures.isDropped(client) {
+			active = append(active, client)
+			continue
+		}
+
+		skipped = append(skipped, &HostResult{
+			Host:       play.hostName(client),
+			Status:     StatusSkipped,
+			ExitStatus: -1,
+		})
+	}
+
+	return
+}
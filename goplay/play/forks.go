@@ -0,0 +1,46 @@
+package play
+
+import (
+	"context"
+)
+
+// semaphore bounds number of concurrent operations, e.g. SSH handshakes and
+// running sessions. The nil semaphore has no limit.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+
+	return make(semaphore, n)
+}
+
+// acquire blocks until a slot is available, it returns ctx.Err() if ctx is
+// done before.
+func (s semaphore) acquire(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if s == nil {
+		return nil
+	}
+
+	select {
+	case s <- struct{}{}:
+		return nil
+
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases the slot acquired.
+func (s semaphore) release() {
+	if s == nil {
+		return
+	}
+
+	<-s
+}
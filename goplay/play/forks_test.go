@@ -0,0 +1,37 @@
+package play
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+)
+
+func Test_Semaphore(t *testing.T) {
+	assertion := assert.New(t)
+
+	ctx := context.Background()
+
+	// no limit
+	var unlimited semaphore
+	for i := 0; i < 3; i++ {
+		assertion.Nil(unlimited.acquire(ctx))
+	}
+	unlimited.release()
+
+	limited := newSemaphore(1)
+	assertion.Nil(limited.acquire(ctx))
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	assertion.Equal(context.DeadlineExceeded, limited.acquire(timeoutCtx))
+	assertion.Equal(context.DeadlineExceeded, unlimited.acquire(timeoutCtx))
+
+	limited.release()
+	assertion.Nil(limited.acquire(ctx))
+
+	assertion.Nil(newSemaphore(0))
+	assertion.Nil(newSemaphore(-1))
+}
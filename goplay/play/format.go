@@ -0,0 +1,311 @@
+package play
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Supported formats of Playfile.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+var (
+	tomlTable    = regexp.MustCompile(`^\[\[?[\w."' -]+\]\]?$`)
+	tomlKeyValue = regexp.MustCompile(`^[\w."-]+\s*=`)
+
+	// yamlBlockScalar matches line leading block scalar without indentation,
+	// e.g. "run: |", "- >-" and "script: !tag |+ # comment".
+	yamlBlockScalar = regexp.MustCompile(`^(?:-\s+)*(?:(?:"[^"]*"|'[^']*'|[^\s#'"][^#]*?):\s+)?(?:![^\s]*\s+)?[|>][1-9+-]*\s*(?:#.*)?$`)
+)
+
+// DetectFormat returns format of Playfile by extension of filename, it
+// detects by content of data if the extension is unknown.
+func DetectFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		return FormatYAML
+
+	case ".json":
+		return FormatJSON
+
+	case ".toml":
+		return FormatTOML
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return FormatJSON
+	}
+
+	// TOML if the first significant line is a table or key = value.
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if tomlTable.MatchString(line) || tomlKeyValue.MatchString(line) {
+			return FormatTOML
+		}
+
+		break
+	}
+
+	return FormatYAML
+}
+
+// toYAML converts data of format given to YAML, order of keys is preserved.
+func toYAML(data []byte, format string) ([]byte, error) {
+	var (
+		doc interface{}
+		err error
+	)
+
+	switch format {
+	case FormatYAML:
+		return expandIndentTabs(data), nil
+
+	case FormatJSON:
+		doc, err = decodeOrderedJSON(json.NewDecoder(bytes.NewReader(data)))
+
+	case FormatTOML:
+		doc, err = decodeOrderedTOML(data)
+
+	default:
+		err = errors.Errorf("unsupported format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(doc)
+}
+
+// expandIndentTabs replaces tabs of indentation with two spaces, tabs of
+// others are kept, e.g. within quoted strings and contents of block scalars.
+// Indentation of block scalar is taken from its first non-empty line as YAML
+// does, tabs following it are contents, e.g. heredoc of <<-EOF.
+func expandIndentTabs(data []byte) []byte {
+	var (
+		inBlock     bool
+		parentWidth int    // Width of indentation of the line leading block scalar.
+		blockIndent []byte // Indentation of block scalar, it's nil before its first non-empty line.
+	)
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		n := 0
+		for n < len(line) && (line[n] == ' ' || line[n] == '\t') {
+			n++
+		}
+
+		if inBlock {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			if indentWidth(line[:n]) > parentWidth {
+				if blockIndent == nil {
+					blockIndent = line[:n:n]
+				}
+
+				if bytes.HasPrefix(line, blockIndent) {
+					lines[i] = append(expandTabs(blockIndent), line[len(blockIndent):]...)
+				}
+
+				continue
+			}
+
+			inBlock = false
+		}
+
+		if yamlBlockScalar.Match(bytes.TrimRight(line[n:], "\r")) {
+			inBlock, parentWidth, blockIndent = true, indentWidth(line[:n]), nil
+		}
+
+		if bytes.IndexByte(line[:n], '\t') == -1 {
+			continue
+		}
+
+		lines[i] = append(expandTabs(line[:n:n]), line[n:]...)
+	}
+
+	return bytes.Join(lines, []byte("\n"))
+}
+
+// expandTabs returns indent with tabs replaced by two spaces.
+func expandTabs(indent []byte) []byte {
+	return bytes.Replace(indent, []byte("\t"), []byte("  "), -1)
+}
+
+// indentWidth returns width of indent with tabs expanded, see expandTabs.
+func indentWidth(indent []byte) int {
+	return len(indent) + bytes.Count(indent, []byte("\t"))
+}
+
+// decodeOrderedJSON decodes next JSON value of decoder, objects are decoded
+// as yaml.MapSlice for keeping order of keys.
+func decodeOrderedJSON(decoder *json.Decoder) (interface{}, error) {
+	decoder.UseNumber()
+
+	token, err := decoder.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, errors.Wrap(err, "decoding JSON failed")
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		switch value {
+		case '{':
+			items := yaml.MapSlice{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, errors.Wrap(err, "decoding JSON failed")
+				}
+
+				item, err := decodeOrderedJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+
+				items = append(items, yaml.MapItem{
+					Key:   key,
+					Value: item,
+				})
+			}
+
+			// consume '}'
+			_, err = decoder.Token()
+			return items, err
+
+		case '[':
+			list := []interface{}{}
+			for decoder.More() {
+				item, err := decodeOrderedJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+
+				list = append(list, item)
+			}
+
+			// consume ']'
+			_, err = decoder.Token()
+			return list, err
+		}
+
+		return nil, errors.Errorf("decoding JSON failed: unexpected %v", value)
+
+	case json.Number:
+		if i, err := value.Int64(); err == nil {
+			return i, nil
+		}
+
+		return value.Float64()
+	}
+
+	return token, nil
+}
+
+// decodeOrderedTOML decodes TOML data, tables are decoded as yaml.MapSlice
+// for keeping order of keys.
+func decodeOrderedTOML(data []byte) (interface{}, error) {
+	var doc map[string]interface{}
+
+	md, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding TOML failed")
+	}
+
+	return orderTOMLValue(doc, nil, md.Keys()), nil
+}
+
+func orderTOMLValue(value interface{}, path toml.Key, keys []toml.Key) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var (
+			items = yaml.MapSlice{}
+			seen  = make(map[string]bool, len(v))
+		)
+
+		// keys in order of document
+		for _, key := range keys {
+			if len(key) != len(path)+1 || !hasTOMLKeyPrefix(key, path) {
+				continue
+			}
+
+			name := key[len(path)]
+			if _, ok := v[name]; !ok || seen[name] {
+				continue
+			}
+			seen[name] = true
+
+			items = append(items, yaml.MapItem{
+				Key:   name,
+				Value: orderTOMLValue(v[name], key, keys),
+			})
+		}
+
+		// others, e.g. keys of tables within array, in order of name
+		var names []string
+		for name := range v {
+			if !seen[name] {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			items = append(items, yaml.MapItem{
+				Key:   name,
+				Value: orderTOMLValue(v[name], append(path[:len(path):len(path)], name), keys),
+			})
+		}
+
+		return items
+
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = orderTOMLValue(item, nil, nil)
+		}
+
+		return list
+
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = orderTOMLValue(item, nil, nil)
+		}
+
+		return list
+	}
+
+	return value
+}
+
+func hasTOMLKeyPrefix(key, prefix toml.Key) bool {
+	for i := range prefix {
+		if key[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,141 @@
+package play
+
+import (
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_DetectFormat(t *testing.T) {
+	assertion := assert.New(t)
+
+	assertion.Equal(FormatYAML, DetectFormat("Playfile.yml", nil))
+	assertion.Equal(FormatJSON, DetectFormat("Playfile.JSON", nil))
+	assertion.Equal(FormatTOML, DetectFormat("Playfile.toml", nil))
+
+	assertion.Equal(FormatYAML, DetectFormat("", []byte(playfile)))
+	assertion.Equal(FormatJSON, DetectFormat("Playfile", []byte(`  {"version": "1.0.0"}`)))
+	assertion.Equal(FormatTOML, DetectFormat("Playfile", []byte("# comment\nversion = \"1.0.0\"\n")))
+	assertion.Equal(FormatTOML, DetectFormat("Playfile", []byte("[networks.all]\nhosts = []\n")))
+}
+
+func Test_NewPlayfileWithJSON(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`{
+	"version": "1.0.0",
+	"envs": {"Z_NAME": "goplay", "A_IMAGE": "example/ci"},
+	"networks": {
+		"db": {"user": "root", "port": 22, "hosts": ["127.0.0.1"]},
+		"app": {"user": "root", "port": 22, "hosts": ["127.0.0.1"]}
+	},
+	"commands": {
+		"echo": {"run": "echo\t$Z_NAME", "serial": 2},
+		"date": {"run": "date", "once": true}
+	},
+	"books": {"all": ["echo", "date"]}
+}`))
+	assertion.Nil(err)
+	assertion.Equal("1.0.0", pfile.Version)
+	assertion.Equal([]string{"Z_NAME=goplay", "A_IMAGE=example/ci"}, pfile.Envs.Slice())
+	assertion.Equal([]string{"db", "app"}, pfile.Networks.Names)
+	assertion.Equal([]string{"echo", "date"}, pfile.Commands.Names)
+
+	network, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal(22, network.Port)
+	assertion.Equal([]string{"127.0.0.1"}, network.Hosts)
+
+	cmd, ok := pfile.Commands.Get("echo")
+	assertion.True(ok)
+	assertion.Equal("echo\t$Z_NAME", cmd.Run)
+	assertion.Equal(Serial{{Size: 2}}, cmd.Serial)
+
+	book, ok := pfile.Books.Get("all")
+	assertion.True(ok)
+	assertion.Equal([]string{"echo", "date"}, book)
+}
+
+func Test_NewPlayfileWithTOML(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfileWithFormat([]byte(`
+version = "1.0.0"
+
+[envs]
+Z_NAME = "goplay"
+A_IMAGE = "example/ci"
+
+[networks.db]
+user = "root"
+port = 22
+hosts = ["127.0.0.1"]
+
+[networks.app]
+user = "root"
+hosts = ["127.0.0.1"]
+
+[commands.echo]
+run = "echo\t$Z_NAME"
+serial = 2
+
+[commands.date]
+run = "date"
+once = true
+
+[books]
+all = ["echo", "date"]
+`), FormatTOML)
+	assertion.Nil(err)
+	assertion.Equal("1.0.0", pfile.Version)
+	assertion.Equal([]string{"Z_NAME=goplay", "A_IMAGE=example/ci"}, pfile.Envs.Slice())
+	assertion.Equal([]string{"db", "app"}, pfile.Networks.Names)
+	assertion.Equal([]string{"echo", "date"}, pfile.Commands.Names)
+
+	network, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal(22, network.Port)
+
+	cmd, ok := pfile.Commands.Get("echo")
+	assertion.True(ok)
+	assertion.Equal("echo\t$Z_NAME", cmd.Run)
+	assertion.Equal(Serial{{Size: 2}}, cmd.Serial)
+
+	cmd, ok = pfile.Commands.Get("date")
+	assertion.True(ok)
+	assertion.Equal(OnceRandom, cmd.Once)
+}
+
+func Test_NewPlayfileWithTabs(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte("---\nversion: 1.0.0\ncommands:\n\techo:\n\t\trun: \"printf 'a\tb'\"\n"))
+	assertion.Nil(err)
+
+	cmd, ok := pfile.Commands.Get("echo")
+	assertion.True(ok)
+	assertion.Equal("printf 'a\tb'", cmd.Run)
+}
+
+func Test_NewPlayfileWithTabsInBlockScalar(t *testing.T) {
+	assertion := assert.New(t)
+
+	// indented by spaces, heredoc of run is indented by tabs
+	pfile, err := NewPlayfile([]byte("---\ncommands:\n  config:\n    run: |\n      cat <<-EOF > app.conf\n      \tport: 8080\n      \tEOF\n    desc: Config\n"))
+	assertion.Nil(err)
+
+	cmd, ok := pfile.Commands.Get("config")
+	assertion.True(ok)
+	assertion.Equal("cat <<-EOF > app.conf\n\tport: 8080\n\tEOF\n", cmd.Run)
+	assertion.Equal("Config", cmd.Desc)
+
+	// indented by tabs, tabs following indentation of block are kept
+	pfile, err = NewPlayfile([]byte("---\ncommands:\n\tconfig:\n\t\trun: |- # heredoc\n\t\t\tcat <<-EOF > app.conf\n\n\t\t\t\tport: 8080\n\t\t\t\tEOF\n\t\tdesc: Config\n\t\tnotify:\n\t\t\t- >-\n\t\t\t\treload\n\t\t\t- restart\n"))
+	assertion.Nil(err)
+
+	cmd, ok = pfile.Commands.Get("config")
+	assertion.True(ok)
+	assertion.Equal("cat <<-EOF > app.conf\n\n\tport: 8080\n\tEOF", cmd.Run)
+	assertion.Equal("Config", cmd.Desc)
+	assertion.Equal([]string{"reload", "restart"}, cmd.Notify)
+}
@@ -0,0 +1,133 @@
+package play
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxExpandedHosts limits hosts expanded from a pattern, it guards typos
+	// like [1:100000].
+	maxExpandedHosts = 65536
+)
+
+var (
+	hostRange = regexp.MustCompile(`\[([0-9]+|[a-zA-Z]):([0-9]+|[a-zA-Z])(?::([0-9]+))?\]`)
+)
+
+// ExpandHosts expands range patterns of hosts in order, see ExpandHostPattern.
+func ExpandHosts(hosts []string) ([]string, error) {
+	var expanded []string
+
+	for _, host := range hosts {
+		list, err := ExpandHostPattern(host)
+		if err != nil {
+			return nil, err
+		}
+
+		expanded = append(expanded, list...)
+	}
+
+	return expanded, nil
+}
+
+// ExpandHostPattern expands range patterns of host, it returns host as is if
+// there is no pattern. Patterns are of the form [start:end] or [start:end:step]
+// with both ends inclusive, e.g.
+//
+//	10.0.0.[1:20]           10.0.0.1, 10.0.0.2, ..., 10.0.0.20
+//	web[01:10].example.com  web01.example.com, ..., web10.example.com
+//	db-[a:c]                db-a, db-b, db-c
+//	10.0.[0:1].[1:9:4]      10.0.0.1, 10.0.0.5, 10.0.0.9, 10.0.1.1, ...
+//
+// Numbers leading with 0 are zero-padded to the width of start. Multiple
+// patterns of a host are expanded from left to right.
+func ExpandHostPattern(host string) ([]string, error) {
+	loc := hostRange.FindStringSubmatchIndex(host)
+	if loc == nil {
+		return []string{host}, nil
+	}
+
+	prefix, suffix := host[:loc[0]], host[loc[1]:]
+	start, end := host[loc[2]:loc[3]], host[loc[4]:loc[5]]
+
+	step := 1
+	if loc[6] >= 0 {
+		step, _ = strconv.Atoi(host[loc[6]:loc[7]])
+		if step <= 0 {
+			return nil, errors.Errorf("invalid host pattern %s: step must be positive", host)
+		}
+	}
+
+	items, err := expandRange(start, end, step)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid host pattern %s", host)
+	}
+
+	// expand patterns left in suffix
+	suffixes, err := ExpandHostPattern(suffix)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(items)*len(suffixes) > maxExpandedHosts {
+		return nil, errors.Errorf("invalid host pattern %s: more than %d hosts", host, maxExpandedHosts)
+	}
+
+	hosts := make([]string, 0, len(items)*len(suffixes))
+	for _, item := range items {
+		for _, s := range suffixes {
+			hosts = append(hosts, prefix+item+s)
+		}
+	}
+
+	return hosts, nil
+}
+
+func expandRange(start, end string, step int) ([]string, error) {
+	var items []string
+
+	startNum, startErr := strconv.Atoi(start)
+	endNum, endErr := strconv.Atoi(end)
+
+	switch {
+	case startErr == nil && endErr == nil:
+		if startNum > endNum {
+			return nil, errors.Errorf("start %s is greater than end %s", start, end)
+		}
+		if (endNum-startNum)/step+1 > maxExpandedHosts {
+			return nil, errors.Errorf("more than %d hosts", maxExpandedHosts)
+		}
+
+		format := "%d"
+		if len(start) > 1 && strings.HasPrefix(start, "0") {
+			format = "%0" + strconv.Itoa(len(start)) + "d"
+		}
+
+		for i := startNum; i <= endNum; i += step {
+			items = append(items, fmt.Sprintf(format, i))
+		}
+
+	case startErr != nil && endErr != nil:
+		from, to := start[0], end[0]
+		if (from >= 'a') != (to >= 'a') {
+			return nil, errors.Errorf("start %s and end %s are of different cases", start, end)
+		}
+		if from > to {
+			return nil, errors.Errorf("start %s is greater than end %s", start, end)
+		}
+
+		for c := int(from); c <= int(to); c += step {
+			items = append(items, string(rune(c)))
+		}
+
+	default:
+		return nil, errors.Errorf("start %s and end %s are of different types", start, end)
+	}
+
+	return items, nil
+}
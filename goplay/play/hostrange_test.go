@@ -0,0 +1,78 @@
+package play
+
+import (
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_ExpandHostPattern(t *testing.T) {
+	assertion := assert.New(t)
+
+	for pattern, hosts := range map[string][]string{
+		"10.0.0.1":               {"10.0.0.1"},
+		"10.0.0.[1:3]":           {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		"web[08:10].example.com": {"web08.example.com", "web09.example.com", "web10.example.com"},
+		"web[001:2]":             {"web001", "web002"},
+		"10.0.0.[1:9:4]":         {"10.0.0.1", "10.0.0.5", "10.0.0.9"},
+		"db-[a:c]":               {"db-a", "db-b", "db-c"},
+		"db-[A:E:2]":             {"db-A", "db-C", "db-E"},
+		"10.0.[0:1].[1:2]":       {"10.0.0.1", "10.0.0.2", "10.0.1.1", "10.0.1.2"},
+		"deploy@web[1:2]:2222":   {"deploy@web1:2222", "deploy@web2:2222"},
+		"[::1]:22":               {"[::1]:22"},
+		"[fe80::1]":              {"[fe80::1]"},
+	} {
+		expanded, err := ExpandHostPattern(pattern)
+		assertion.Nil(err, pattern)
+		assertion.Equal(hosts, expanded, pattern)
+	}
+
+	for _, pattern := range []string{
+		"10.0.0.[3:1]",
+		"10.0.0.[1:3:0]",
+		"db-[a:3]",
+		"db-[a:C]",
+		"10.0.[0:255].[0:255].[0:255]",
+	} {
+		_, err := ExpandHostPattern(pattern)
+		assertion.NotNil(err, pattern)
+	}
+}
+
+func Test_NetworksHostRange(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+networks:
+  all:
+    hosts:
+      - 10.0.0.[1:4]
+      - web[01:02].example.com
+
+  app:
+    hosts_from: all - db
+
+  db:
+    hosts:
+      - 10.0.0.[3:4]
+`))
+	assertion.Nil(err)
+
+	all, ok := pfile.Networks.Get("all")
+	assertion.True(ok)
+	assertion.Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "web01.example.com", "web02.example.com"}, all.Hosts)
+
+	app, ok := pfile.Networks.Get("app")
+	assertion.True(ok)
+	assertion.Equal([]string{"10.0.0.1", "10.0.0.2", "web01.example.com", "web02.example.com"}, app.Hosts)
+
+	// patterns are kept by definitions
+	def, ok := pfile.Networks.Definition("all")
+	assertion.True(ok)
+	assertion.Equal([]string{"10.0.0.[1:4]", "web[01:02].example.com"}, def.Hosts)
+
+	_, err = NewPlayfile([]byte("---\nversion: 1.0.0\nnetworks:\n  a:\n    hosts: [10.0.0.[3:1]]\n"))
+	assertion.NotNil(err)
+}
@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/exec"
 	"os/user"
+	"strings"
+	"syscall"
 )
 
 // LocalClient is a wrapper over the local host.
@@ -24,7 +26,8 @@ type LocalClient struct {
 // NewLocalClient creates a local client with given env, and
 // export PLAY_HOST=localhost
 func NewLocalClient(env string) *LocalClient {
-	if env[len(env)-1] != ';' {
+	env = strings.TrimSpace(env)
+	if env != "" && !strings.HasSuffix(env, ";") {
 		env += ";"
 	}
 
@@ -51,7 +54,10 @@ func (c *LocalClient) Run(book *Book) error {
 		return ErrRunning
 	}
 
-	cmd := exec.Command("bash", "-c", c.env+book.run)
+	cmd := exec.Command("bash", "-c", c.env+book.command(c))
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true, // for signaling children of bash, see Signal.
+	}
 
 	c.cmd = cmd
 
@@ -122,10 +128,15 @@ func (c *LocalClient) Stderr() io.Reader {
 	return c.stderr
 }
 
+// Signal sends sig to the running book, all processes of the book are
+// signaled, including children of bash.
+// NOTE: It's called while waiting, so lastError is not touched.
 func (c *LocalClient) Signal(sig os.Signal) error {
-	c.lastError = c.cmd.Process.Signal(sig)
+	if s, ok := sig.(syscall.Signal); ok {
+		return syscall.Kill(-c.cmd.Process.Pid, s)
+	}
 
-	return c.lastError
+	return c.cmd.Process.Signal(sig)
 }
 
 func (c *LocalClient) Prompt() string {
@@ -0,0 +1,404 @@
+package play
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// lockedExitStatus is exit status of lock script if the lock is held by
+// others, it's EX_TEMPFAIL of sysexits.h.
+const lockedExitStatus = 75
+
+var rlockName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Lock defines exclusive lock of book taken on each host before any of its
+// commands run, it prevents concurrent runs of the book, e.g. two deploys of
+// the same service at the same moment. The lock is a file of each host, see
+// LockFile, which holds owner, PID and time of the run holding it.
+//
+// It's given by "lock: true" for lock named with the book, by name shared
+// between books, e.g. "lock: app", or with options, e.g.
+// "lock: {name: app, stale: 2h, network: true}".
+type Lock struct {
+	Name    string
+	Stale   time.Duration // Age of lock taken as stale, 0 means lock is stale only if its process is gone.
+	Network bool          // Lock is of the whole network, nothing runs if any host is locked by others.
+}
+
+// NewLock returns lock with name given, the name must consist of letters,
+// digits, '_', '.' and '-'.
+func NewLock(name string) (*Lock, error) {
+	if !rlockName.MatchString(name) {
+		return nil, errors.Errorf("invalid lock name %q, it must consist of letters, digits, '_', '.' and '-'", name)
+	}
+
+	return &Lock{
+		Name: name,
+	}, nil
+}
+
+// LockFile returns path of lock file with name given on hosts.
+func LockFile(name string) string {
+	return "/tmp/goplay-" + name + ".lock"
+}
+
+func (l *Lock) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		// NOTE: name of lock defaults to name of book, see Books.
+		if !v {
+			return errors.New("invalid lock false, omit it for no lock")
+		}
+
+	case string:
+		l.Name = v
+
+	case map[interface{}]interface{}:
+		var def struct {
+			Name    string        `yaml:"name"`
+			Stale   time.Duration `yaml:"stale"`
+			Network bool          `yaml:"network"`
+		}
+
+		err = unmarshal(&def)
+		if err != nil {
+			return err
+		}
+
+		l.Name, l.Stale, l.Network = def.Name, def.Stale, def.Network
+
+	default:
+		return errors.Errorf("invalid lock %v, it must be true, name or mapping", value)
+	}
+
+	if l.Name != "" && !rlockName.MatchString(l.Name) {
+		return errors.Errorf("invalid lock name %q, it must consist of letters, digits, '_', '.' and '-'", l.Name)
+	}
+	if l.Stale < 0 {
+		return errors.Errorf("invalid lock stale %v, it must not be negative", l.Stale)
+	}
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, Lock without options is marshaled as
+// its name.
+func (l Lock) MarshalYAML() (interface{}, error) {
+	if l.Stale == 0 && !l.Network {
+		return l.Name, nil
+	}
+
+	items := yaml.MapSlice{
+		{Key: "name", Value: l.Name},
+	}
+	if l.Stale > 0 {
+		items = append(items, yaml.MapItem{Key: "stale", Value: l.Stale.String()})
+	}
+	if l.Network {
+		items = append(items, yaml.MapItem{Key: "network", Value: true})
+	}
+
+	return items, nil
+}
+
+// lockHolder represents run holding lock, it's the content of lock file.
+type lockHolder struct {
+	Owner string    `json:"owner"` // user@host of goplay.
+	PID   int       `json:"pid"`   // PID of goplay.
+	Time  time.Time `json:"time"`
+	Run   string    `json:"run,omitempty"` // ID of the run, see RunState.
+}
+
+func (h *lockHolder) String() string {
+	s := fmt.Sprintf("%s (pid %d) since %s", h.Owner, h.PID, h.Time.Format(time.RFC3339))
+	if h.Run != "" {
+		s += ", run " + h.Run
+	}
+
+	return s
+}
+
+// stale returns true if holder is older than maxAge given, or its process is
+// gone on the local host.
+func (h *lockHolder) stale(maxAge time.Duration) bool {
+	if maxAge > 0 && time.Since(h.Time) > maxAge {
+		return true
+	}
+
+	hostname, _ := os.Hostname()
+	if !strings.HasSuffix(h.Owner, "@"+hostname) || h.PID <= 0 {
+		return false
+	}
+
+	process, err := os.FindProcess(h.PID)
+	if err != nil {
+		return true
+	}
+
+	err = process.Signal(syscall.Signal(0))
+
+	return err != nil && err != syscall.EPERM
+}
+
+// lockContent returns content of lock file held by the current process.
+func (play *Play) lockContent() string {
+	owner := "unknown"
+	if cu, err := user.Current(); err == nil {
+		owner = cu.Username
+	}
+
+	hostname, _ := os.Hostname()
+
+	holder := &lockHolder{
+		Owner: owner + "@" + hostname,
+		PID:   os.Getpid(),
+		Time:  time.Now().UTC().Truncate(time.Second),
+	}
+	if play.state != nil {
+		holder.Run = play.state.ID
+	}
+
+	data, _ := json.Marshal(holder)
+
+	return string(data)
+}
+
+// lockHosts takes lock of the run with content given on clients in parallel,
+// see Play.Lock. Lock held by others is taken over if it's stale or forced,
+// see Play.ForceUnlock. Clients locked and result of locking are returned,
+// hosts failed to lock are reported as failed.
+func (play *Play) lockHosts(ctx context.Context, clients []Client, content string) (locked []Client, result *CommandResult) {
+	result = &CommandResult{
+		Name:  play.lock.Name,
+		Start: time.Now(),
+		Lock:  true,
+	}
+	defer func() {
+		result.End = time.Now()
+	}()
+
+	file := LockFile(play.lock.Name)
+
+	book := &BookResult{
+		Run:   "lock " + file,
+		Hosts: make([]*HostResult, len(clients)),
+	}
+	for i, client := range clients {
+		book.Hosts[i] = &HostResult{
+			Host:   play.hostName(client),
+			Status: StatusFailed,
+			client: client,
+		}
+	}
+	result.Books = []*BookResult{book}
+
+	var wg sync.WaitGroup
+	for _, host := range book.Hosts {
+		wg.Add(1)
+
+		go func(c Client, host *HostResult) {
+			defer wg.Done()
+
+			host.Start = time.Now()
+			host.Attempts = 1
+			defer func() {
+				host.End = time.Now()
+				host.ExitStatus = exitStatus(host.Err)
+			}()
+
+			err := play.forks.acquire(ctx)
+			if err != nil {
+				host.Err = err
+				return
+			}
+			defer play.forks.release()
+
+			host.Err = play.lockHost(ctx, c, file, content)
+		}(host.client, host)
+	}
+	wg.Wait()
+
+	for _, host := range book.Hosts {
+		prompt := PadStringWithTimestamp(host.client.Prompt(), play.promptLen)
+
+		switch {
+		case host.Err == nil:
+			host.Status = StatusOK
+
+			locked = append(locked, host.client)
+
+		case ctx.Err() == context.DeadlineExceeded:
+			host.Status = StatusTimedOut
+
+		case ctx.Err() == context.Canceled:
+			host.Status = StatusCancelled
+		}
+
+		if host.Err != nil {
+			Errorf("%s%v\n", prompt, host.Err)
+		}
+	}
+
+	if len(locked) > 0 {
+		Infof("Locked %s on %d host(s)\n", file, len(locked))
+	}
+
+	return
+}
+
+// lockHost takes lock file with content given on client, lock held by others
+// is taken over if it's stale or forced.
+func (play *Play) lockHost(ctx context.Context, c Client, file, content string) error {
+	out, err := play.runScript(ctx, c, lockScript(file, content))
+	if exitStatus(err) != lockedExitStatus {
+		if err != nil {
+			return errors.Wrapf(err, "locking %s failed", file)
+		}
+
+		return nil
+	}
+
+	prompt := PadStringWithTimestamp(c.Prompt(), play.promptLen)
+
+	old := strings.TrimRight(string(out), "\n")
+
+	// NOTE: the same host may be given more than once, e.g. by alias.
+	if old == content {
+		return nil
+	}
+
+	var holder lockHolder
+	switch {
+	case old == "":
+		// NOTE: lock is released meanwhile, it's taken again.
+
+	case json.Unmarshal([]byte(old), &holder) != nil:
+		if !play.force {
+			return errors.Errorf("%s is locked by unknown holder: %s", file, old)
+		}
+
+		Warnf("%sForce unlocking %s held by unknown holder: %s\n", prompt, file, old)
+
+	case play.force:
+		Warnf("%sForce unlocking %s held by %v\n", prompt, file, &holder)
+
+	case holder.stale(play.lock.Stale):
+		Warnf("%sTaking over stale lock %s held by %v\n", prompt, file, &holder)
+
+	default:
+		return errors.Errorf("%s is locked by %v", file, &holder)
+	}
+
+	out, err = play.runScript(ctx, c, takeoverScript(file, old, content))
+	if exitStatus(err) == lockedExitStatus {
+		if strings.TrimRight(string(out), "\n") == content {
+			return nil
+		}
+
+		return errors.Errorf("%s is locked by %s", file, strings.TrimSpace(string(out)))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "locking %s failed", file)
+	}
+
+	return nil
+}
+
+// unlockHosts releases lock of the run with content given on clients in
+// parallel, locks taken over by others are kept. Failures are reported as
+// warnings.
+func (play *Play) unlockHosts(clients []Client, content string) {
+	file := LockFile(play.lock.Name)
+
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+
+		go func(c Client) {
+			defer wg.Done()
+
+			// NOTE: locks are released even if the run is cancelled.
+			_, err := play.runScript(context.Background(), c, unlockScript(file, content))
+			if err != nil {
+				Warnf("%s%v\n", PadStringWithTimestamp(c.Prompt(), play.promptLen), errors.Wrapf(err, "unlocking %s failed", file))
+			}
+		}(client)
+	}
+	wg.Wait()
+}
+
+// runScript runs script on client, and returns its STDOUT.
+func (play *Play) runScript(ctx context.Context, c Client, script string) (stdout []byte, err error) {
+	err = c.Run(&Book{
+		run: script,
+	})
+	if err != nil {
+		return
+	}
+
+	// NOTE: close STDIN only, Close() of ssh client closes its connection.
+	c.Stdin().Close()
+
+	err = play.waitBook(ctx, c, func() error {
+		var wg sync.WaitGroup
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			io.Copy(ioutil.Discard, c.Stderr())
+		}()
+
+		stdout, _ = ioutil.ReadAll(c.Stdout())
+		wg.Wait()
+
+		return c.Wait()
+	})
+
+	return
+}
+
+// lockScript returns script creating lock file with content given, it exits
+// with lockedExitStatus and prints content of the lock if it exists.
+func lockScript(file, content string) string {
+	return fmt.Sprintf(`if ( set -C; printf '%%s\n' %s > %s ) 2>/dev/null; then exit 0; fi; cat %s 2>/dev/null; exit %d`,
+		shellQuote(content), shellQuote(file), shellQuote(file), lockedExitStatus)
+}
+
+// takeoverScript returns script replacing lock file of old content with new
+// content, the lock taken by others meanwhile is kept.
+func takeoverScript(file, old, content string) string {
+	return fmt.Sprintf(`[ "$(cat %s 2>/dev/null)" = %s ] && rm -f %s; %s`,
+		shellQuote(file), shellQuote(old), shellQuote(file), lockScript(file, content))
+}
+
+// unlockScript returns script removing lock file if it's of content given.
+func unlockScript(file, content string) string {
+	return fmt.Sprintf(`if [ "$(cat %s 2>/dev/null)" = %s ]; then rm -f %s; fi`,
+		shellQuote(file), shellQuote(content), shellQuote(file))
+}
+
+// shellQuote returns s quoted by single quotes for shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,149 @@
+package play
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_BooksWithLock(t *testing.T) {
+	assertion := assert.New(t)
+
+	var pfile Playfile
+
+	err := yaml.Unmarshal([]byte(`
+books:
+  build: [compile, test]
+  deploy:
+    commands: [upload, restart]
+    lock: true
+  migrate:
+    commands: [migrate]
+    lock: app
+  release:
+    commands: [upload, restart]
+    lock: {name: app, stale: 2h, network: true}
+`), &pfile)
+	assertion.Nil(err)
+	assertion.Nil(pfile.Books.Lock("build"))
+	assertion.Equal(&Lock{Name: "deploy"}, pfile.Books.Lock("deploy"))
+	assertion.Equal(&Lock{Name: "app"}, pfile.Books.Lock("migrate"))
+	assertion.Equal(&Lock{Name: "app", Stale: 2 * time.Hour, Network: true}, pfile.Books.Lock("release"))
+
+	data, err := yaml.Marshal(pfile.Books)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "deploy:\n  commands:\n  - upload\n  - restart\n  lock: deploy\n")
+	assertion.Contains(string(data), "  lock:\n    name: app\n    stale: 2h0m0s\n    network: true\n")
+
+	err = yaml.Unmarshal([]byte("books:\n  deploy:\n    commands: [upload]\n    lock: ../app\n"), &pfile)
+	assertion.NotNil(err)
+
+	err = yaml.Unmarshal([]byte("books:\n  deploy:\n    commands: [upload]\n    lock: false\n"), &pfile)
+	assertion.NotNil(err)
+}
+
+func Test_LockHolderStale(t *testing.T) {
+	assertion := assert.New(t)
+
+	hostname, _ := os.Hostname()
+
+	holder := &lockHolder{
+		Owner: "root@" + hostname,
+		PID:   os.Getpid(),
+		Time:  time.Now().Add(-time.Hour),
+	}
+	assertion.False(holder.stale(0))
+	assertion.False(holder.stale(2 * time.Hour))
+	assertion.True(holder.stale(time.Minute))
+
+	// process of other hosts can't be checked
+	holder.Owner = "root@" + hostname + ".example.com"
+	holder.PID = 1 << 30
+	assertion.False(holder.stale(0))
+
+	holder.Owner = "root@" + hostname
+	assertion.True(holder.stale(0))
+}
+
+func Test_PlayRunContextWithLock(t *testing.T) {
+	assertion := assert.New(t)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	lock, err := NewLock(fmt.Sprintf("test-%d", os.Getpid()))
+	assertion.Nil(err)
+
+	file := LockFile(lock.Name)
+	defer os.Remove(file)
+
+	cmd := &Command{
+		Name: "deploy",
+		Run:  "cat " + file,
+	}
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+	player.Lock(lock)
+
+	// lock is taken before commands, and released after
+	result, err := player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Nil(err)
+	assertion.Len(result.Commands, 2)
+	assertion.True(result.Commands[0].Lock)
+	assertion.Equal(lock.Name, result.Commands[0].Name)
+	assertion.Equal(Stats{OK: 4}, result.Stats())
+
+	_, err = os.Stat(file)
+	assertion.True(os.IsNotExist(err))
+
+	// hosts locked by others are failed
+	held := `{"owner":"alice@elsewhere","pid":1,"time":"2020-01-02T03:04:05Z"}`
+	assertion.Nil(ioutil.WriteFile(file, []byte(held+"\n"), 0644))
+
+	result, err = player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Equal(ErrFailures{Stats{Failed: 2}}, err)
+	assertion.Len(result.Commands, 1)
+	assertion.Contains(result.Commands[0].Books[0].Hosts[0].Err.Error(), "is locked by alice@elsewhere (pid 1)")
+
+	data, err := ioutil.ReadFile(file)
+	assertion.Nil(err)
+	assertion.Equal(held+"\n", string(data))
+
+	// lock of network aborts the run
+	lock.Network = true
+
+	result, err = player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Equal(ErrFailures{Stats{Failed: 2}}, err)
+	assertion.Equal("lock "+lock.Name+" of network is held on 2 host(s)", result.Aborted)
+
+	// lock stale is taken over
+	lock.Stale = time.Hour
+
+	result, err = player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Nil(err)
+	assertion.Equal(Stats{OK: 4}, result.Stats())
+
+	_, err = os.Stat(file)
+	assertion.True(os.IsNotExist(err))
+
+	// lock is taken over by force
+	lock.Stale = 0
+	assertion.Nil(ioutil.WriteFile(file, []byte(held+"\n"), 0644))
+
+	player.ForceUnlock(true)
+
+	result, err = player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Nil(err)
+	assertion.Equal(Stats{OK: 4}, result.Stats())
+
+	_, err = os.Stat(file)
+	assertion.True(os.IsNotExist(err))
+}
@@ -0,0 +1,298 @@
+package play
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resolve resolves extends and hosts_from of all networks, it fails on
+// unknown or cyclic references.
+func (n *Networks) resolve() error {
+	var (
+		nets     = make(map[string]Network, len(n.defs))
+		visiting = make(map[string]bool)
+		visit    func(name string, path []string) (Network, error)
+	)
+
+	visit = func(name string, path []string) (Network, error) {
+		if net, ok := nets[name]; ok {
+			return net, nil
+		}
+
+		def, ok := n.defs[name]
+		if !ok {
+			return Network{}, errors.Errorf("network %s does not exist", name)
+		}
+
+		path = append(path[:len(path):len(path)], name)
+		if visiting[name] {
+			return Network{}, errors.Errorf("cyclic reference of networks: %s", strings.Join(path, " -> "))
+		}
+
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		hosts, err := ExpandHosts(def.Hosts)
+		if err != nil {
+			return Network{}, err
+		}
+		def.Hosts = hosts
+
+		net := def
+		if def.Extends != "" {
+			parent, err := visit(def.Extends, path)
+			if err != nil {
+				return Network{}, err
+			}
+
+			net = extendNetwork(parent, def)
+		}
+
+		if def.HostsFrom != "" {
+			hosts, err := evalHostsExpr(def.HostsFrom, func(ref string) ([]string, error) {
+				refNet, err := visit(ref, path)
+				return refNet.Hosts, err
+			})
+			if err != nil {
+				return Network{}, err
+			}
+
+			net.Hosts = unionHosts(hosts, def.Hosts)
+		}
+
+		nets[name] = net
+
+		return net, nil
+	}
+
+	for _, name := range n.Names {
+		_, err := visit(name, nil)
+		if err != nil {
+			return errors.Wrapf(err, "resolving network %s failed", name)
+		}
+	}
+
+	n.nets = nets
+
+	return nil
+}
+
+// extendNetwork returns child with settings inherited from parent. Settings
+// defined by child take precedence, env files and env vars are merged with
+// child's overriding. Hosts are inherited only if child has neither hosts
+// nor hosts_from.
+func extendNetwork(parent, child Network) Network {
+	net := child
+
+	if net.Bastion == "" {
+		net.Bastion = parent.Bastion
+	}
+	if net.Inventory == "" {
+		net.Inventory = parent.Inventory
+	}
+	if net.User == "" {
+		net.User = parent.User
+	}
+	if net.Passwd == "" {
+		net.Passwd = parent.Passwd
+	}
+	if net.Port == 0 {
+		net.Port = parent.Port
+	}
+	if net.IdentityFile == "" {
+		net.IdentityFile = parent.IdentityFile
+	}
+	if len(net.Hosts) == 0 && net.HostsFrom == "" {
+		net.Hosts = append([]string(nil), parent.Hosts...)
+	}
+
+	net.EnvFile = append(append(EnvFiles(nil), parent.EnvFile...), child.EnvFile...)
+
+	net.Envs = nil
+	for _, v := range parent.Envs {
+		net.Envs.setVar(*v)
+	}
+	for _, v := range child.Envs {
+		net.Envs.setVar(*v)
+	}
+
+	return net
+}
+
+// evalHostsExpr evaluates set expression of networks and returns hosts in
+// order of first appearance. Operators are evaluated from left to right with
+// the same precedence, use parentheses for grouping:
+//
+//	a | b, a + b    union
+//	a & b           intersection
+//	a - b           exclusion, spaces around - are required
+//
+// Hosts of network are looked up by lookup.
+func evalHostsExpr(expr string, lookup func(name string) ([]string, error)) ([]string, error) {
+	tokens := tokenizeHostsExpr(expr)
+
+	parser := &hostsExprParser{
+		tokens: tokens,
+		lookup: lookup,
+	}
+
+	hosts, err := parser.expr()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid hosts_from %q", expr)
+	}
+
+	if parser.pos < len(tokens) {
+		return nil, errors.Errorf("invalid hosts_from %q: unexpected %q", expr, tokens[parser.pos])
+	}
+
+	return hosts, nil
+}
+
+func tokenizeHostsExpr(expr string) (tokens []string) {
+	var token []rune
+
+	flush := func() {
+		if len(token) > 0 {
+			tokens = append(tokens, string(token))
+			token = token[:0]
+		}
+	}
+
+	for _, r := range expr {
+		switch r {
+		case ' ', '\t', '\n':
+			flush()
+
+		case '(', ')', '|', '+', '&':
+			flush()
+			tokens = append(tokens, string(r))
+
+		default:
+			token = append(token, r)
+		}
+	}
+	flush()
+
+	return
+}
+
+type hostsExprParser struct {
+	tokens []string
+	pos    int
+	lookup func(name string) ([]string, error)
+}
+
+func (p *hostsExprParser) expr() ([]string, error) {
+	hosts, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.pos < len(p.tokens) {
+		op := p.tokens[p.pos]
+		if op == ")" {
+			break
+		}
+
+		p.pos++
+
+		others, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case "|", "+":
+			hosts = unionHosts(hosts, others)
+
+		case "&":
+			hosts = intersectHosts(hosts, others)
+
+		case "-":
+			hosts = excludeHosts(hosts, others)
+
+		default:
+			return nil, errors.Errorf("unexpected %q, operator is required", op)
+		}
+	}
+
+	return hosts, nil
+}
+
+func (p *hostsExprParser) term() ([]string, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end, network is required")
+	}
+
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token {
+	case "(":
+		hosts, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.pos >= len(p.tokens) || p.tokens[p.pos] != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+
+		return hosts, nil
+
+	case ")", "|", "+", "&", "-":
+		return nil, errors.Errorf("unexpected %q, network is required", token)
+	}
+
+	return p.lookup(token)
+}
+
+func unionHosts(hosts, others []string) []string {
+	result := make([]string, 0, len(hosts)+len(others))
+	seen := make(map[string]bool, len(hosts)+len(others))
+
+	for _, list := range [][]string{hosts, others} {
+		for _, host := range list {
+			if !seen[host] {
+				seen[host] = true
+				result = append(result, host)
+			}
+		}
+	}
+
+	return result
+}
+
+func intersectHosts(hosts, others []string) []string {
+	contains := make(map[string]bool, len(others))
+	for _, host := range others {
+		contains[host] = true
+	}
+
+	var result []string
+	for _, host := range unionHosts(hosts, nil) {
+		if contains[host] {
+			result = append(result, host)
+		}
+	}
+
+	return result
+}
+
+func excludeHosts(hosts, others []string) []string {
+	contains := make(map[string]bool, len(others))
+	for _, host := range others {
+		contains[host] = true
+	}
+
+	var result []string
+	for _, host := range unionHosts(hosts, nil) {
+		if !contains[host] {
+			result = append(result, host)
+		}
+	}
+
+	return result
+}
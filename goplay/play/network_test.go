@@ -0,0 +1,112 @@
+package play
+
+import (
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_NetworksComposition(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+networks:
+  all:
+    user: root
+    port: 2222
+    env:
+      REGION: cn
+      ROLE: all
+    hosts:
+      - 10.0.0.1
+      - 10.0.0.2
+      - 10.0.0.3
+      - 10.0.0.4
+
+  app:
+    hosts:
+      - 10.0.0.1
+      - 10.0.0.2
+
+  pfd:
+    hosts:
+      - 10.0.0.2
+      - 10.0.0.3
+
+  db:
+    extends: all
+    user: mysql
+    env:
+      ROLE: db
+    hosts:
+      - 10.0.0.4
+
+  others:
+    extends: all
+    hosts_from: all - db - (app & pfd)
+
+  edges:
+    hosts_from: app|pfd
+    hosts:
+      - 10.0.1.1
+
+  mirror:
+    extends: all
+`))
+	assertion.Nil(err)
+
+	db, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal("db", db.Name)
+	assertion.Equal("mysql", db.User)
+	assertion.Equal(2222, db.Port)
+	assertion.Equal([]string{"10.0.0.4"}, db.Hosts)
+	assertion.Equal([]string{"REGION=cn", "ROLE=db"}, db.Envs.Slice())
+
+	others, ok := pfile.Networks.Get("others")
+	assertion.True(ok)
+	assertion.Equal("root", others.User)
+	assertion.Equal([]string{"10.0.0.1", "10.0.0.3"}, others.Hosts)
+
+	edges, ok := pfile.Networks.Get("edges")
+	assertion.True(ok)
+	assertion.Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.1.1"}, edges.Hosts)
+
+	mirror, ok := pfile.Networks.Get("mirror")
+	assertion.True(ok)
+	assertion.Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}, mirror.Hosts)
+
+	// definitions are kept
+	def, ok := pfile.Networks.Definition("others")
+	assertion.True(ok)
+	assertion.Empty(def.Hosts)
+	assertion.Equal("all - db - (app & pfd)", def.HostsFrom)
+
+	// referenced network can't be deleted
+	assertion.NotNil(pfile.Networks.Delete("db"))
+
+	// cyclic reference is rejected, and nothing changes
+	assertion.NotNil(pfile.Networks.Set("all", Network{Extends: "mirror"}))
+
+	all, ok := pfile.Networks.Get("all")
+	assertion.True(ok)
+	assertion.Equal(4, len(all.Hosts))
+}
+
+func Test_NetworksCompositionErrors(t *testing.T) {
+	assertion := assert.New(t)
+
+	for _, networks := range []string{
+		"a:\n    extends: b\n  b:\n    extends: a\n",
+		"a:\n    hosts_from: a | b\n  b:\n    hosts: [10.0.0.1]\n",
+		"a:\n    extends: unknown\n",
+		"a:\n    hosts_from: b c\n  b:\n    hosts: [10.0.0.1]\n  c:\n    hosts: [10.0.0.2]\n",
+		"a:\n    hosts_from: (b | c\n  b:\n    hosts: [10.0.0.1]\n  c:\n    hosts: [10.0.0.2]\n",
+		"a:\n    hosts_from: b &\n  b:\n    hosts: [10.0.0.1]\n",
+	} {
+		_, err := NewPlayfile([]byte("---\nversion: 1.0.0\nnetworks:\n  " + networks))
+		assertion.NotNil(err, networks)
+	}
+}
@@ -1,22 +1,44 @@
 package play
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/signal"
 	"sync"
+	"time"
 
 	"github.com/goware/prefixer"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
+const (
+	// cancelGracePeriod is the duration for books to exit after signaled by
+	// cancellation, they are killed after it.
+	cancelGracePeriod = 5 * time.Second
+)
+
 // Play holds all books for running
 type Play struct {
-	config *Playfile
-	prompt bool
-	debug  bool
+	config    *Playfile
+	network   *Network
+	bastion   *SSHClient
+	hosts     map[Client]*HostContext
+	failures  *failureTracker
+	forks     semaphore // Bounds concurrent SSH handshakes and running sessions.
+	canary    *Canary   // Hosts running all commands before the rest, see Play.Canary.
+	check     bool      // Compares uploads with remote files only, see Play.Check.
+	lock      *Lock     // Lock taken on hosts before running, see Play.Lock.
+	force     bool      // Locks held by others are taken over, see Play.ForceUnlock.
+	promptLen int       // Max length of client prompts, for aligning outputs.
+	prompt    bool
+	debug     bool
+	dryRun    bool
+
+	notified map[string]map[Client]bool // Hosts notified of each handler, see Command.Notify.
+	state    *RunState                  // Progress of the run for resuming, see Play.State.
+	step     string                     // Step of the command running, it's empty if not tracked by state.
 }
 
 // New returns *Play with config
@@ -27,27 +49,376 @@ func New(config *Playfile) (*Play, error) {
 }
 
 // Run runs set of commands on multiple hosts defined by network sequentially.
-// TODO: This megamoth method needs a big refactor and should be split
-//       to multiple smaller methods.
-func (play *Play) Run(network *Network, envs EnvVars, commands ...*Command) error {
+// It's the same as RunContext with context.Background().
+func (play *Play) Run(network *Network, envs EnvVars, commands ...*Command) (*Result, error) {
+	return play.RunContext(context.Background(), network, envs, commands...)
+}
+
+// RunContext runs set of commands on multiple hosts defined by network
+// sequentially. It works in stages:
+//
+//	connect  connects all hosts of network in parallel
+//	plan     creates books of each command for hosts connected
+//	execute  runs books of each command on their hosts
+//
+// Cancelling ctx stops pending commands and books, running books are signaled
+// with os.Interrupt, and killed if they are still running after a grace
+// period. Connections are closed on return. It returns ctx.Err() if ctx is
+// cancelled. Commands with timeout are cancelled in the same way, the run
+// goes on with next commands.
+//
+// Hosts failed drop out of later books and commands by default, see
+// FailurePolicy. The run is aborted once failed hosts exceed max fail
+// percentage, pending books and commands are not run.
+//
+// All commands run on canary hosts first if canary of the run is given, see
+// Play.Canary.
+//
+// Handlers notified by commands run after all commands in order of Playfile,
+// each of them runs once on hosts notified only, see Command.Notify. They are
+// not run if the run is aborted.
+//
+// Hosts are locked before running any of commands if lock of the run is
+// given, see Play.Lock.
+//
+// Progress of the run is saved after each book if state of the run is given,
+// books completed on hosts before are skipped for resuming, see Play.State.
+//
+// Result of each command, book and host is returned, it's nil if nothing is
+// run, e.g. dry run. It returns ErrFailures if any of hosts failed, or
+// ErrCanaryDeclined if any canary is declined.
+func (play *Play) RunContext(ctx context.Context, network *Network, envs EnvVars, commands ...*Command) (*Result, error) {
 	if len(commands) == 0 {
-		return ErrEmptyCommand
+		return nil, ErrEmptyCommand
+	}
+
+	err := play.validateNotify(commands)
+	if err != nil {
+		return nil, err
+	}
+
+	// Print plan of books only without connecting for dry run.
+	if play.dryRun {
+		return nil, play.printPlan(os.Stdout, network, envs, commands...)
+	}
+
+	networkEnvs, err := play.config.EnvsFor(network, nil, envs)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving env vars failed")
+	}
+
+	result := &Result{
+		Network: network.Name,
+		Start:   time.Now(),
+	}
+	defer func() {
+		result.End = time.Now()
+	}()
+
+	clients, unreachable, err := play.connect(ctx, network, networkEnvs)
+	result.Unreachable = unreachable
+	if err != nil {
+		return result, err
+	}
+	defer play.disconnect(clients)
+
+	play.failures = newFailureTracker(len(network.Hosts), len(unreachable))
+	play.notified = make(map[string]map[Client]bool)
+	play.restoreNotified(clients)
+	if _, maxFail := play.failurePolicy(nil); play.failures.exceeds(maxFail) {
+		play.failures.aborted = fmt.Sprintf("%d of %d host(s) are unreachable, exceeding max_fail_percentage %d%%", len(unreachable), len(network.Hosts), maxFail)
+	}
+
+	// Lock hosts before running any of commands, see Lock.
+	if play.lock != nil && !play.check {
+		content := play.lockContent()
+
+		locked, lockResult := play.lockHosts(ctx, clients, content)
+		defer play.unlockHosts(locked, content)
+
+		result.Commands = append(result.Commands, lockResult)
+
+		if n := len(clients) - len(locked); n > 0 {
+			if play.lock.Network {
+				play.failures.aborted = fmt.Sprintf("lock %s of network is held on %d host(s)", play.lock.Name, n)
+			} else {
+				_, maxFail := play.failurePolicy(nil)
+				play.failures.record(lockResult.Books[0].Hosts, FailureSkipHost, maxFail)
+			}
+		}
+
+		clients = locked
+	}
+
+	// Run commands on canary hosts first, and the rest if it's promoted.
+	if play.canary != nil {
+		canary, rest := play.canary.split(clients)
+		for _, client := range canary {
+			result.Canary = append(result.Canary, play.hostName(client))
+		}
+
+		// NOTE: failures of canary are of its commands only, e.g. hosts
+		// unreachable or locked by others are not of the canary.
+		n := len(result.Commands)
+
+		play.runCommands(ctx, network, canary, commands, envs, networkEnvs, result)
+
+		if ctx.Err() == nil && play.failures.aborted == "" && len(rest) > 0 {
+			if failures := commandStats(result.Commands[n:]).Failures(); failures > 0 {
+				play.failures.aborted = fmt.Sprintf("canary of run failed on %d host(s)", failures)
+			} else {
+				result.Promoted = play.promoteCanary(ctx, "run", play.canary, canary, len(rest), networkEnvs.AsExport(), networkEnvs.Secrets())
+			}
+		}
+
+		clients = rest
+	}
+
+	play.runCommands(ctx, network, clients, commands, envs, networkEnvs, result)
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+
+	if play.failures.aborted != "" {
+		result.Aborted = play.failures.aborted
+
+		Errorf("Run aborted: %s\n", result.Aborted)
+	}
+
+	if len(result.Rollbacks) > 0 {
+		if stats := result.RollbackStats(); stats.Failures() > 0 {
+			Errorf("Rollback summary: %v\n", stats)
+		} else {
+			Infof("Rollback summary: %v\n", stats)
+		}
+	}
+
+	stats := result.Stats()
+	if stats.Failures() > 0 {
+		Errorf("Summary: %v\n", stats)
+
+		return result, ErrFailures{stats}
+	}
+
+	if play.failures.declined != nil {
+		Warnf("Summary: %v\n", stats)
+
+		return result, *play.failures.declined
+	}
+
+	Infof("Summary: %v\n", stats)
+
+	return result, nil
+}
+
+// runCommands runs commands on clients sequentially, and handlers notified by
+// them at the end. It stops if ctx is cancelled or the run is aborted. Results
+// of commands and handlers are added to result.
+func (play *Play) runCommands(ctx context.Context, network *Network, clients []Client, commands []*Command, envs, networkEnvs EnvVars, result *Result) {
+	if len(clients) == 0 {
+		return
+	}
+
+	for i, cmd := range commands {
+		if ctx.Err() != nil || play.failures.aborted != "" {
+			return
+		}
+
+		play.step = fmt.Sprintf("%d.%s", i+1, cmd.Name)
+
+		cmdResult := play.runCommand(ctx, network, clients, cmd, envs, networkEnvs)
+
+		result.Commands = append(result.Commands, cmdResult)
+
+		play.rollback(ctx, network, cmd, cmdResult, envs, networkEnvs, result)
+
+		play.notify(cmd, cmdResult)
+	}
+
+	play.runHandlers(ctx, network, clients, envs, networkEnvs, result)
+}
+
+// runHandlers runs handlers notified in order of Playfile, each of them runs
+// once on clients notified only. Handlers may notify handlers defined after
+// them. Notifications are cleared once handlers succeeded on hosts, the rest
+// are kept for resuming, see Play.State.
+func (play *Play) runHandlers(ctx context.Context, network *Network, clients []Client, envs, networkEnvs EnvVars, result *Result) {
+	defer play.saveNotified()
+
+	if play.config == nil {
+		return
+	}
+
+	for _, name := range play.config.Handlers.Names {
+		if ctx.Err() != nil || play.failures.aborted != "" {
+			return
+		}
+
+		var notified []Client
+		for _, client := range clients {
+			if play.notified[name][client] {
+				notified = append(notified, client)
+			}
+		}
+		if len(notified) == 0 {
+			continue
+		}
+
+		// NOTE: nothing is changed for check, handlers are reported only.
+		if play.check {
+			Warnf("Handler %s would be notified on %s\n", name, play.hostNames(notified))
+			continue
+		}
+
+		handler, _ := play.config.Handlers.Get(name)
+		handler.Name = name
+
+		Infof("Handler %s notified on %d host(s)\n", name, len(notified))
+
+		play.step = "handler." + name
+
+		cmdResult := play.runCommand(ctx, network, notified, &handler, envs, networkEnvs)
+		cmdResult.Handler = true
+
+		result.Commands = append(result.Commands, cmdResult)
+
+		play.rollback(ctx, network, &handler, cmdResult, envs, networkEnvs, result)
+
+		_, succeeded := commandHosts(cmdResult)
+		for _, client := range succeeded {
+			delete(play.notified[name], client)
+		}
+
+		play.notify(&handler, cmdResult)
+	}
+}
+
+// notify notifies handlers of cmd on hosts changed by it, see Command.Notify.
+func (play *Play) notify(cmd *Command, result *CommandResult) {
+	for _, book := range result.Books {
+		for _, host := range book.Hosts {
+			if !host.Changed || host.Status != StatusOK || host.client == nil {
+				continue
+			}
+
+			for _, name := range cmd.Notify {
+				if play.notified[name] == nil {
+					play.notified[name] = make(map[Client]bool)
+				}
+
+				play.notified[name][host.client] = true
+			}
+		}
+	}
+
+	if len(cmd.Notify) > 0 {
+		play.saveNotified()
+	}
+}
+
+// validateNotify returns error if any of handlers notified by commands, or
+// by handlers, does not exist.
+func (play *Play) validateNotify(commands []*Command) error {
+	var handlers Commands
+	if play.config != nil {
+		handlers = play.config.Handlers
+	}
+
+	all := append([]*Command{}, commands...)
+	for _, name := range handlers.Names {
+		handler, _ := handlers.Get(name)
+		handler.Name = name
+
+		all = append(all, &handler)
+	}
+
+	for _, cmd := range all {
+		for _, name := range cmd.Notify {
+			if _, ok := handlers.Get(name); !ok {
+				return errors.Errorf("handler %s notified by command %s does not exist", name, cmd.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// runCommand plans and executes cmd on clients, cmd is cancelled once its
+// timeout expires.
+func (play *Play) runCommand(ctx context.Context, network *Network, clients []Client, cmd *Command, envs, networkEnvs EnvVars) *CommandResult {
+	result := &CommandResult{
+		Name:  cmd.Name,
+		Start: time.Now(),
+	}
+	defer func() {
+		result.End = time.Now()
+	}()
+
+	books, cmdEnvs, err := play.plan(network, clients, cmd, envs, networkEnvs)
+	if err != nil {
+		Errorf("%v\n", err)
+
+		result.Err = err
+
+		return result
+	}
+
+	// Books are tracked by state of the run for resuming, see Play.State.
+	if play.state != nil && play.step != "" {
+		for _, book := range books {
+			book.step = play.bookStep(book)
+		}
+	}
+
+	secrets := cmdEnvs.Secrets()
+
+	cmdCtx, cancel := ctx, context.CancelFunc(nil)
+	if cmd.Timeout > 0 {
+		cmdCtx, cancel = context.WithTimeout(ctx, cmd.Timeout)
 	}
 
+	switch {
+	case play.check:
+		result.Books = play.checkUploads(cmdCtx, cmd, books)
+
+	case cmd.Once != "":
+		result.Books, result.OnceHost = play.executeOnce(cmdCtx, cmd, books, secrets)
+
+	default:
+		play.execute(cmdCtx, cmd, books, cmdEnvs, result)
+	}
+
+	// NOTE: pending books of command timed out are skipped.
+	if cmdCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		Errorf("Command %s timed out after %v\n", cmd.Name, cmd.Timeout)
+	}
+
+	if cancel != nil {
+		cancel()
+	}
+
+	return result
+}
+
+// connect connects all hosts of network in parallel, hosts failed to connect
+// are reported and skipped, and returned as unreachable. It fails if none of
+// hosts is connected.
+func (play *Play) connect(ctx context.Context, network *Network, envs EnvVars) ([]Client, []*HostResult, error) {
 	// Create bastion for every host (either SSH or Localhost).
-	var bastion *SSHClient
 	if network.Bastion != "" {
-		bastion = &SSHClient{}
-		if err := bastion.Connect(network.Bastion); err != nil {
-			return errors.Wrap(err, fmt.Sprintf("%s: connecting to bastion failed", network.Bastion))
+		play.bastion = &SSHClient{}
+		if err := play.bastion.Connect(network.Bastion); err != nil {
+			play.bastion = nil
+
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("%s: connecting to bastion failed", network.Bastion))
 		}
 	}
 
-	clientCh := make(chan Client, len(network.Hosts))
 	clientEnv := envs.AsExport()
 
 	var (
-		wg sync.WaitGroup
+		wg      sync.WaitGroup
+		clients = make([]Client, len(network.Hosts))
 	)
 	for i, host := range network.Hosts {
 		wg.Add(1)
@@ -55,6 +426,12 @@ func (play *Play) Run(network *Network, envs EnvVars, commands ...*Command) erro
 		go func(i int, host string) {
 			defer wg.Done()
 
+			// NOTE: dialing can't be interrupted, skip it if cancelled.
+			if play.forks.acquire(ctx) != nil {
+				return
+			}
+			defer play.forks.release()
+
 			switch host {
 			case "localhost", "127.0.0.1": // localhost client
 				local := &LocalClient{
@@ -63,7 +440,7 @@ func (play *Play) Run(network *Network, envs EnvVars, commands ...*Command) erro
 
 				local.Connect(host)
 
-				clientCh <- local
+				clients[i] = local
 
 			default: // ssh client
 				remote := &SSHClient{
@@ -71,180 +448,779 @@ func (play *Play) Run(network *Network, envs EnvVars, commands ...*Command) erro
 					user: network.User,
 				}
 
-				if bastion != nil {
-					remote.ConnectWith(host, bastion.dialThrough)
+				if play.bastion != nil {
+					remote.ConnectWith(host, play.bastion.dialThrough)
 				} else {
 					remote.Connect(host)
 				}
 
-				clientCh <- remote
-
+				clients[i] = remote
 			}
 		}(i, host)
 	}
 	wg.Wait()
 
-	close(clientCh)
+	play.network = network
+	play.hosts = make(map[Client]*HostContext, len(network.Hosts))
 
 	var (
-		clients      []Client
-		maxPromptLen = 0
+		connected   []Client
+		unreachable []*HostResult
 	)
-	for client := range clientCh {
+	for i, client := range clients {
+		if client == nil {
+			continue
+		}
+
 		lastErr := client.LastError()
 		if lastErr != nil {
-			fmt.Fprintf(os.Stderr, "%v\n", errors.Wrap(lastErr, "connecting failed"))
+			err := errors.Wrap(lastErr, "connecting failed")
+
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+
+			unreachable = append(unreachable, &HostResult{
+				Host:       MaskUserHostWithPasswd(network.Hosts[i]),
+				Status:     StatusUnreachable,
+				ExitStatus: -1,
+				Err:        err,
+			})
 
 			continue
 		}
 
-		// hook for ssh client
-		if remote, ok := client.(*SSHClient); ok {
-			defer remote.Close()
+		hostCtx, err := NewHostContext(network, i, network.Hosts[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", errors.Wrap(err, "resolving host context failed"))
+
+			// NOTE: clients without host context are closed, but not used.
+			if remote, ok := client.(*SSHClient); ok {
+				remote.Close()
+			}
+
+			continue
 		}
+		play.hosts[client] = hostCtx
 
-		clients = append(clients, client)
+		connected = append(connected, client)
 
 		prompt := client.Prompt()
-		if len(prompt) > maxPromptLen {
-			maxPromptLen = len(prompt)
+		if len(prompt) > play.promptLen {
+			play.promptLen = len(prompt)
 		}
 	}
 
-	if len(clients) == 0 {
-		return ErrEmptyClient
+	if ctx.Err() != nil {
+		play.disconnect(connected)
+
+		return nil, unreachable, ctx.Err()
 	}
 
-	// Run commands defined by target sequentially.
-	// TODO: should gather all outputs and calc stats
-	for _, cmd := range commands {
-		// build book(s) from command.
-		books, err := play.createBooks(clients, cmd, clientEnv)
+	if len(connected) == 0 {
+		play.disconnect(connected)
+
+		return nil, unreachable, ErrEmptyClient
+	}
+
+	return connected, unreachable, nil
+}
+
+// disconnect closes connections of clients and bastion.
+func (play *Play) disconnect(clients []Client) {
+	for _, client := range clients {
+		// hook for ssh client
+		if remote, ok := client.(*SSHClient); ok {
+			remote.Close()
+		}
+	}
+
+	if play.bastion != nil {
+		play.bastion.Close()
+		play.bastion = nil
+	}
+}
+
+// plan creates books of cmd for clients, and returns them with env vars of
+// cmd, whose secrets are masked in outputs.
+func (play *Play) plan(network *Network, clients []Client, cmd *Command, envs, networkEnvs EnvVars) ([]*Book, EnvVars, error) {
+	// layer command env vars over network's if provided.
+	cmdEnvs := networkEnvs
+	if len(cmd.Env) > 0 || len(cmd.EnvFile) > 0 {
+		var err error
+
+		cmdEnvs, err = play.config.EnvsFor(network, cmd, envs)
 		if err != nil {
-			Errorf("%v\n", errors.Wrapf(err, "creating book %v failed", cmd))
+			return nil, nil, errors.Wrapf(err, "resolving env vars of %v failed", cmd)
+		}
+	}
 
-			continue
+	// build book(s) from command.
+	books, err := play.createBooks(clients, cmd, cmdEnvs, networkEnvs)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "creating book %v failed", cmd)
+	}
+
+	return books, cmdEnvs, nil
+}
+
+// execute runs books of cmd sequentially, it stops if ctx is cancelled or the
+// run is aborted. Hosts dropped for failures are skipped, see FailurePolicy.
+// Batches of serial command are paused between, and the run is aborted if
+// health check of any batch fails. Books of canary run first, the rest of
+// books run only if the canary succeeds and is promoted, see Canary. Outputs
+// of books are masked with secrets of env vars given, which are exported to
+// check of canary too. Results of books run are added to result given.
+func (play *Play) execute(ctx context.Context, cmd *Command, books []*Book, envs EnvVars, result *CommandResult) {
+	policy, maxFail := play.failurePolicy(cmd)
+
+	secrets := envs.Secrets()
+
+	var (
+		batches        int
+		rest           int // Number of hosts not in canary.
+		canaryFailures int
+	)
+	for i, book := range books {
+		if i == 0 || book.batch != books[i-1].batch {
+			batches++
+
+			if !book.canary {
+				rest += len(book.clients)
+			}
 		}
+	}
 
-		// Run books sequentially.
-		for _, book := range books {
-			var (
-				writers []io.Writer
-				bookWg  sync.WaitGroup
-			)
-
-			// Run books on the provided clients.
-			for _, client := range book.clients {
-				err := client.Run(book)
-				if err != nil {
-					Errorf("%s%v\n", PadStringWithTimestamp(client.Prompt(), maxPromptLen), errors.Wrapf(err, "running book %v failed", book))
-
-					continue
-				}
+	if len(books) > 0 && books[0].canary {
+		for _, client := range books[0].clients {
+			result.Canary = append(result.Canary, play.hostName(client))
+		}
+	}
 
-				// Copy over book's STDOUT.
-				bookWg.Add(1)
-				go func(c Client) {
-					defer bookWg.Done()
-
-					err := pcopy(
-						os.Stdout,
-						prefixer.New(c.Stdout(), PadStringWithTimestamp(c.Prompt(), maxPromptLen)),
-						pinfo)
-					if err != nil && err != io.EOF {
-						// TODO: io.Copy() should not return io.EOF at all.
-						// Upstream bug? Or prefixer.WriteTo() bug?
-						Errorf("%s%v\n", PadStringWithTimestamp(c.Prompt(), maxPromptLen), errors.Wrap(err, "reading STDOUT failed"))
-					}
-				}(client)
-
-				// Copy over book's STDERR.
-				bookWg.Add(1)
-				go func(c Client) {
-					defer bookWg.Done()
-
-					err := pcopy(
-						os.Stderr,
-						prefixer.New(c.Stderr(), PadStringWithTimestamp(c.Prompt(), maxPromptLen)),
-						perror)
-					if err != nil && err != io.EOF {
-						Errorf("%s%v\n", PadStringWithTimestamp(c.Prompt(), maxPromptLen), errors.Wrap(err, "reading STDERR failed"))
-					}
-				}(client)
+	for i, book := range books {
+		if ctx.Err() != nil || play.failures.aborted != "" {
+			return
+		}
 
-				writers = append(writers, client.Stdin())
+		promoting := i > 0 && books[i-1].canary && !book.canary
+		if promoting {
+			if canaryFailures > 0 {
+				play.failures.aborted = fmt.Sprintf("canary of command %s failed on %d host(s)", cmd.Name, canaryFailures)
+				return
 			}
 
-			// Copy over book's STDIN.
-			if book.input != nil {
-				go func() {
-					writer := io.MultiWriter(writers...)
+			result.Promoted = play.promoteCanary(ctx, "command "+cmd.Name, cmd.Canary, books[0].clients, rest, envs.AsExport(), secrets)
+			if !result.Promoted {
+				return
+			}
+		}
 
-					_, err := io.Copy(writer, book.input)
-					if err != nil && err != io.EOF {
-						Errorf("%v\n", errors.Wrap(err, "writing STDIN failed"))
-					}
+		if i > 0 && book.batch != books[i-1].batch && cmd.PauseBetweenBatches > 0 && !promoting {
+			Infof("Command %s pausing %v before batch %d/%d\n", cmd.Name, cmd.PauseBetweenBatches, book.batch+1, batches)
 
-					// TODO: Use MultiWriteCloser (not in Stdlib), so we can writer.Close() instead?
-					for _, client := range clients {
-						client.Close()
-					}
-				}()
+			timer := time.NewTimer(cmd.PauseBetweenBatches)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+
+			case <-timer.C:
+			}
+		}
+
+		clients, skipped := play.skipDropped(book.clients)
+		clients = play.skipCompleted(cmd, book, clients)
+
+		bookResult := &BookResult{
+			Run: book.run,
+		}
+		if len(clients) > 0 {
+			copy := *book
+			copy.clients = clients
+
+			bookResult = play.executeBook(ctx, &copy, secrets)
+		}
+		play.completeBook(book, bookResult.Hosts)
+
+		bookResult.Hosts = append(bookResult.Hosts, skipped...)
+
+		failures := play.failures.record(bookResult.Hosts, policy, maxFail)
+		if book.healthCheck && failures > 0 && play.failures.aborted == "" {
+			play.failures.aborted = fmt.Sprintf("health check of command %s failed on %d host(s) of batch %d/%d", cmd.Name, failures, book.batch+1, batches)
+		}
+		if book.canary {
+			canaryFailures += failures
+		}
+
+		result.Books = append(result.Books, bookResult)
+	}
+}
+
+// executeOnce runs books of once command on the first candidate, it fails over
+// to next candidate if any of books fails on it. Candidates are clients of
+// books, see createBooks. It stops if ctx is cancelled.
+//
+// Results of books run are returned with the host succeeded, failures of hosts
+// before it are marked as StatusFailedOver. Hosts dropped for failures are not
+// candidates, they are returned as skipped.
+func (play *Play) executeOnce(ctx context.Context, cmd *Command, books []*Book, secrets []string) (results []*BookResult, onceHost string) {
+	if len(books) == 0 {
+		return
+	}
+
+	candidates, skipped := play.skipDropped(books[0].clients)
+	defer func() {
+		if len(skipped) > 0 {
+			results = append(results, &BookResult{
+				Run:   books[0].run,
+				Hosts: skipped,
+			})
+		}
+
+		var hosts []*HostResult
+		for _, result := range results {
+			hosts = append(hosts, result.Hosts...)
+		}
+
+		policy, maxFail := play.failurePolicy(cmd)
+		play.failures.record(hosts, policy, maxFail)
+	}()
+
+	if len(candidates) == 0 {
+		return
+	}
+
+	if client := play.completedOnce(books, candidates); client != nil {
+		onceHost = play.hostName(client)
+
+		Infof("Command %s skipped, it ran once on %s before resuming\n", cmd.Name, onceHost)
+
+		return
+	}
+
+	for i, client := range candidates {
+		var err error
+		for _, book := range books {
+			if ctx.Err() != nil {
+				return
 			}
 
-			// Catch OS signals and pass them to all active clients.
-			trap := make(chan os.Signal, 1)
-			signal.Notify(trap, os.Interrupt)
-			go func() {
-				for {
-					select {
-					case sig, ok := <-trap:
-						if !ok {
-							return
-						}
-
-						for _, client := range book.clients {
-							err := client.Signal(sig)
-							if err != nil {
-								Errorf("%s%v\n", PadStringWithTimestamp(client.Prompt(), maxPromptLen), errors.Wrapf(err, "sending signal %v failed", sig))
-							}
-						}
+			copy := *book
+			copy.clients = []Client{client}
+
+			result := play.executeBook(ctx, &copy, secrets)
+			results = append(results, result)
+
+			err = result.Hosts[0].Err
+			if err != nil {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		prompt := PadStringWithTimestamp(client.Prompt(), play.promptLen)
+
+		if err == nil {
+			onceHost = play.hostName(client)
+
+			for _, result := range results {
+				for _, host := range result.Hosts {
+					if host.Status == StatusFailed {
+						host.Status = StatusFailedOver
 					}
 				}
-			}()
-
-			// Wait for all I/O operations first.
-			bookWg.Wait()
-
-			// Make sure each client finishes the book, return on failure.
-			for _, client := range book.clients {
-				bookWg.Add(1)
-
-				go func(c Client) {
-					defer bookWg.Done()
-
-					prompt := PadStringWithTimestamp(c.Prompt(), maxPromptLen)
-
-					err := c.Wait()
-					if err != nil {
-						// TODO: Store all the errors, and print them after Wait().
-						if e, ok := err.(*ssh.ExitError); ok && e.ExitStatus() != 15 {
-							Errorf("%s%v\n%sexit status %v\n", prompt, e, prompt, e.ExitStatus())
-						} else {
-							Errorf("%s%v\n", prompt, err)
-						}
-					} else {
-						Infof("%sDone!\n", prompt)
-					}
-				}(client)
 			}
 
-			// Wait for all commands to finish.
-			bookWg.Wait()
+			Infof("%sCommand %s ran once on %s\n", prompt, cmd.Name, onceHost)
+
+			for j, book := range books {
+				play.completeBook(book, results[len(results)-len(books)+j].Hosts)
+			}
+
+			return
+		}
+
+		if i+1 < len(candidates) {
+			Warnf("%sCommand %s failed, failing over to next host (%d/%d)\n", prompt, cmd.Name, i+2, len(candidates))
+		}
+	}
+
+	Errorf("Command %s failed on all of %d host(s)\n", cmd.Name, len(candidates))
+
+	return
+}
+
+// executeBook runs book on its clients in parallel and waits for all of them.
+// Clients run the book in a pool bounded by forks, and each of them opens its
+// own input of the book if required, e.g. tar stream of uploads. Book exited
+// with non-zero status is retried on the client if retries of it is given.
+// Errors of clients are reported, and results of them are returned in order
+// of clients.
+func (play *Play) executeBook(ctx context.Context, book *Book, secrets []string) *BookResult {
+	result := &BookResult{
+		Run:   book.run,
+		Hosts: make([]*HostResult, len(book.clients)),
+	}
+
+	// NOTE: host names are resolved here, the map of host contexts is not
+	// safe for concurrent use.
+	for i, client := range book.clients {
+		result.Hosts[i] = &HostResult{
+			Host:   play.hostName(client),
+			Status: StatusFailed,
+			client: client,
+		}
+	}
+
+	// Files of upload changed are diffed before they are overwritten.
+	if book.detectChanges {
+		changes := play.uploadChanges(ctx, book)
+		defer func() {
+			for _, host := range result.Hosts {
+				host.Changed = host.Status == StatusOK && changes[host.client]
+			}
+		}()
+	}
+
+	// Input can't be shared by the pool, clients must start all together.
+	if book.input != nil || (book.openInput != nil && play.forks == nil) {
+		play.executeBookWithInput(ctx, book, secrets, result)
+
+		return result
+	}
+
+	var wg sync.WaitGroup
+	for i, client := range book.clients {
+		wg.Add(1)
+
+		go func(c Client, host *HostResult) {
+			defer wg.Done()
+
+			err := play.forks.acquire(ctx)
+			if err != nil {
+				host.Start = time.Now()
+
+				play.reportBook(ctx, c, host, err)
+				return
+			}
+			defer play.forks.release()
+
+			host.Start = time.Now()
+			host.Attempts = 1
+
+			wait, err := play.startBookWithInput(c, book, secrets, host)
+			if err != nil {
+				play.reportBook(ctx, c, host, err)
+				return
+			}
+
+			play.finishBook(ctx, c, book, secrets, host, wait)
+		}(client, result.Hosts[i])
+	}
+
+	// Wait for all clients to finish.
+	wg.Wait()
+
+	return result
+}
+
+// executeBookWithInput runs book on all its clients together regardless of
+// forks, and copies over input of the book to all of them, e.g. STDIN.
+func (play *Play) executeBookWithInput(ctx context.Context, book *Book, secrets []string, result *BookResult) {
+	var (
+		started []Client
+		writers []io.Writer
+		waits   = make([]func() error, len(book.clients))
+	)
+
+	input := book.input
+	if book.openInput != nil {
+		var err error
+
+		input, err = book.openInput()
+		if err != nil {
+			err = errors.Wrapf(err, "opening input of book %v failed", book)
+
+			Errorf("%v\n", err)
+
+			now := time.Now()
+			for _, host := range result.Hosts {
+				host.ExitStatus = -1
+				host.Start = now
+				host.End = now
+				host.Err = err
+			}
+
+			return
+		}
+	}
+
+	// Run books on the provided clients.
+	for i, client := range book.clients {
+		host := result.Hosts[i]
+		host.Start = time.Now()
+		host.Attempts = 1
+
+		wait, err := play.startBook(client, book, secrets, host)
+		if err != nil {
+			play.reportBook(ctx, client, host, errors.Wrapf(err, "running book %v failed", book))
+
+			continue
+		}
+
+		waits[i] = wait
+
+		started = append(started, client)
+		writers = append(writers, client.Stdin())
+	}
+
+	// Copy over book's STDIN.
+	if len(started) > 0 {
+		go func(clients []Client) {
+			writer := io.MultiWriter(writers...)
+
+			_, err := io.Copy(writer, input)
+			if err != nil && err != io.EOF {
+				Errorf("%v\n", errors.Wrap(err, "writing STDIN failed"))
+			}
+
+			// NOTE: close STDIN only, Close() of ssh client closes its connection.
+			for _, client := range clients {
+				client.Stdin().Close()
+			}
+		}(started)
+	}
+
+	// Make sure each client finishes the book.
+	var wg sync.WaitGroup
+	for i, client := range book.clients {
+		if waits[i] == nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(c Client, host *HostResult, wait func() error) {
+			defer wg.Done()
+
+			play.finishBook(ctx, c, book, secrets, host, wait)
+		}(client, result.Hosts[i], waits[i])
+	}
+
+	// Wait for all commands to finish.
+	wg.Wait()
+}
+
+// startBookWithInput runs book on client, and copies over input opened for
+// the run to its STDIN, e.g. tar stream of uploads. It returns func for waiting
+// the book.
+func (play *Play) startBookWithInput(c Client, book *Book, secrets []string, host *HostResult) (func() error, error) {
+	var input io.Reader
+	if book.openInput != nil {
+		var err error
+
+		input, err = book.openInput()
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening input of book %v failed", book)
+		}
+	}
+
+	wait, err := play.startBook(c, book, secrets, host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "running book %v failed", book)
+	}
+
+	// Copy over book's STDIN.
+	if input != nil {
+		go func() {
+			_, err := io.Copy(c.Stdin(), input)
+			if err != nil && err != io.EOF {
+				Errorf("%s%v\n", PadStringWithTimestamp(c.Prompt(), play.promptLen), errors.Wrap(err, "writing STDIN failed"))
+			}
+
+			// NOTE: close STDIN only, Close() of ssh client closes its connection.
+			c.Stdin().Close()
+		}()
+	}
+
+	return wait, nil
+}
+
+// finishBook waits book started on client, and reports result of it. The book
+// is re-run if it exited with non-zero status and retries of it is given, its
+// input is opened again for each re-run, see Book.openInput. Books reading
+// STDIN are never re-run, for STDIN can't be replayed.
+func (play *Play) finishBook(ctx context.Context, c Client, book *Book, secrets []string, host *HostResult, wait func() error) {
+	err := book.changed(host, play.waitBook(ctx, c, wait))
+
+	retries := book.retries
+	if book.input != nil {
+		retries = 0
+	}
+
+	for attempt := 1; attempt <= retries; attempt++ {
+		if exitStatus(err) <= 0 || ctx.Err() != nil {
+			break
+		}
+
+		delay := retryBackoff(book.retryDelay, attempt)
+
+		Warnf("%s%v, retrying in %v (%d/%d)\n", PadStringWithTimestamp(c.Prompt(), play.promptLen), err, delay.Round(time.Millisecond), attempt, retries)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+		case <-timer.C:
+			host.Attempts++
+
+			wait, err = play.startBookWithInput(c, book, secrets, host)
+			if err == nil {
+				err = book.changed(host, play.waitBook(ctx, c, wait))
+			}
+		}
+	}
+
+	play.reportBook(ctx, c, host, err)
+}
+
+// reportBook reports result of book on client with err given.
+func (play *Play) reportBook(ctx context.Context, c Client, host *HostResult, err error) {
+	host.End = time.Now()
+	host.ExitStatus = exitStatus(err)
+	host.Err = err
+
+	prompt := PadStringWithTimestamp(c.Prompt(), play.promptLen)
+
+	switch {
+	case err == nil:
+		host.Status = StatusOK
+
+		Infof("%sDone!\n", prompt)
+
+	case ctx.Err() == context.DeadlineExceeded:
+		host.Status = StatusTimedOut
+
+		Errorf("%sTimed out!\n", prompt)
+
+	case ctx.Err() == context.Canceled:
+		host.Status = StatusCancelled
+
+		Errorf("%sCancelled!\n", prompt)
+
+	default:
+		// TODO: Store all the errors, and print them after Wait().
+		if e, ok := err.(*ssh.ExitError); ok && e.ExitStatus() != 15 {
+			Errorf("%s%v\n%sexit status %v\n", prompt, e, prompt, e.ExitStatus())
+		} else {
+			Errorf("%s%v\n", prompt, err)
+		}
+	}
+}
+
+// startBook runs book on client and copies over its STDOUT and STDERR, which
+// are masked with secrets given. Bytes of outputs and tail of STDERR are kept
+// by host. It returns func for waiting the book.
+func (play *Play) startBook(client Client, book *Book, secrets []string, host *HostResult) (func() error, error) {
+	err := client.Run(book)
+	if err != nil {
+		return nil, err
+	}
+
+	host.Stdout = 0
+	host.Stderr = 0
+	host.StderrTail = nil
+	host.Changed = false
+
+	prompt := PadStringWithTimestamp(client.Prompt(), play.promptLen)
+
+	stdout := io.Reader(countReader{client.Stdout(), &host.Stdout})
+	if book.changedMarker != "" {
+		stdout = io.TeeReader(stdout, &markerWriter{marker: []byte(book.changedMarker), changed: &host.Changed})
+	}
+
+	var wg sync.WaitGroup
+
+	// Copy over book's STDOUT.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		err := pcopy(os.Stdout, prefixer.New(NewMaskReader(stdout, secrets), prompt), pinfo)
+		if err != nil && err != io.EOF {
+			// TODO: io.Copy() should not return io.EOF at all.
+			// Upstream bug? Or prefixer.WriteTo() bug?
+			Errorf("%s%v\n", prompt, errors.Wrap(err, "reading STDOUT failed"))
+		}
+	}()
+
+	// Copy over book's STDERR.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		err := pcopy(os.Stderr, prefixer.New(io.TeeReader(NewMaskReader(countReader{client.Stderr(), &host.Stderr}, secrets), tailWriter{&host.StderrTail, maxStderrTail}), prompt), perror)
+		if err != nil && err != io.EOF {
+			Errorf("%s%v\n", prompt, errors.Wrap(err, "reading STDERR failed"))
+		}
+	}()
+
+	return func() error {
+		// Wait for all I/O operations first.
+		wg.Wait()
+
+		return client.Wait()
+	}, nil
+}
+
+// waitBook waits book started on client to finish, the client is cancelled
+// once ctx is done, see cancelOnDone.
+func (play *Play) waitBook(ctx context.Context, client Client, wait func() error) error {
+	var (
+		done    = make(chan struct{})
+		stopped = make(chan struct{})
+	)
+
+	go func() {
+		defer close(stopped)
+
+		play.cancelOnDone(ctx, done, []Client{client})
+	}()
+
+	err := wait()
+
+	// NOTE: the client is reused by next books, make sure it's not signaled.
+	close(done)
+	<-stopped
+
+	return err
+}
+
+// cancelOnDone signals clients with os.Interrupt once ctx is done, e.g.
+// cancelled or timed out. It escalates if done is not closed within
+// cancelGracePeriod:
+//
+//	os.Interrupt -> os.Kill and closing ssh session -> closing ssh connection
+func (play *Play) cancelOnDone(ctx context.Context, done <-chan struct{}, clients []Client) {
+	select {
+	case <-done:
+		return
+
+	case <-ctx.Done():
+	}
+
+	for _, client := range clients {
+		err := client.Signal(os.Interrupt)
+		if err != nil {
+			Errorf("%s%v\n", PadStringWithTimestamp(client.Prompt(), play.promptLen), errors.Wrapf(err, "sending signal %v failed", os.Interrupt))
+		}
+	}
+
+	timer := time.NewTimer(cancelGracePeriod)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return
+
+	case <-timer.C:
+	}
+
+	for _, client := range clients {
+		client.Signal(os.Kill)
+
+		// hook for ssh client, the connection is kept for next books.
+		if remote, ok := client.(*SSHClient); ok {
+			remote.closeSession()
+		}
+	}
+
+	timer.Reset(cancelGracePeriod)
+
+	select {
+	case <-done:
+		return
+
+	case <-timer.C:
+	}
+
+	for _, client := range clients {
+		// hook for ssh client, closing connection breaks waiting of hung host.
+		if remote, ok := client.(*SSHClient); ok {
+			remote.Close()
+		}
+	}
+}
+
+// hostName returns user@host of client for reporting, it's prompt of client
+// if context of it is not found.
+func (play *Play) hostName(client Client) string {
+	hostCtx, err := play.hostContext(client)
+	if err != nil {
+		return client.Prompt()
+	}
+
+	return hostCtx.User + "@" + hostCtx.Host
+}
+
+// hostContext returns context of client for rendering templates.
+func (play *Play) hostContext(client Client) (*HostContext, error) {
+	hostCtx, ok := play.hosts[client]
+	if ok {
+		return hostCtx, nil
+	}
+
+	// NOTE: clients created on the fly, e.g. for locally command, are localhost.
+	hostCtx, err := NewHostContext(play.network, 0, "localhost")
+	if err != nil {
+		return nil, err
+	}
+
+	play.hosts[client] = hostCtx
+
+	return hostCtx, nil
+}
+
+// gatherFacts gathers facts of clients in parallel, facts are gathered only
+// once for each client.
+func (play *Play) gatherFacts(clients []Client) error {
+	// NOTE: nothing is run for dry run, facts are left empty.
+	if play.dryRun {
+		return nil
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(clients))
+	)
+	for i, client := range clients {
+		hostCtx, err := play.hostContext(client)
+		if err != nil {
+			return err
+		}
+		if hostCtx.Facts != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, c Client, hostCtx *HostContext) {
+			defer wg.Done()
+
+			facts, err := gatherHostFacts(c)
+			if err != nil {
+				errs[i] = errors.Wrapf(err, "%sgathering facts failed", c.Prompt())
+				return
+			}
+
+			hostCtx.Facts = facts
+		}(i, client, hostCtx)
+	}
+	wg.Wait()
 
-			// Stop catching signals for the currently active clients.
-			signal.Stop(trap)
-			close(trap)
+	for _, err := range errs {
+		if err != nil {
+			return err
 		}
 	}
 
@@ -258,3 +1234,48 @@ func (play *Play) Debug(value bool) {
 func (play *Play) Prompt(value bool) {
 	play.prompt = value
 }
+
+func (play *Play) DryRun(value bool) {
+	play.dryRun = value
+}
+
+// Check sets check mode of the run, uploads of commands are compared with
+// remote files and their diffs are printed, nothing is changed on hosts.
+// Other books of commands are not run.
+func (play *Play) Check(value bool) {
+	play.check = value
+}
+
+// Canary sets canary of the run, all commands run on canary hosts first, and
+// the rest of hosts only if it's promoted, see Canary.
+func (play *Play) Canary(canary *Canary) {
+	play.canary = canary
+}
+
+// State sets state of the run, progress of the run is saved to it after each
+// book, and books completed on hosts by it are skipped for resuming. Handlers
+// notified are saved too, they are notified again once the run is resumed.
+// Rollbacks are not tracked. See RunState.
+func (play *Play) State(state *RunState) {
+	play.state = state
+}
+
+// Lock sets lock taken on each host before running any of commands, hosts
+// locked by others are failed and skipped, or the run is aborted for lock of
+// network. Locks are released once the run finishes. Nothing is locked for
+// check. See Lock.
+func (play *Play) Lock(lock *Lock) {
+	play.lock = lock
+}
+
+// ForceUnlock sets whether locks held by others are taken over, even if they
+// are not stale.
+func (play *Play) ForceUnlock(value bool) {
+	play.force = value
+}
+
+// Forks sets max number of hosts connecting or running books concurrently, 0
+// means no limit. It's independent of serial of commands.
+func (play *Play) Forks(value int) {
+	play.forks = newSemaphore(value)
+}
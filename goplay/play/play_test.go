@@ -0,0 +1,916 @@
+package play
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+)
+
+func Test_PlayRunContext(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	network := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name: "touch",
+		Run:  "touch " + filepath.Join(dir, "touched") + "; echo touched",
+	})
+	assertion.Nil(err)
+	assertion.False(result.Failed())
+	assertion.Equal(1, result.Stats().OK)
+
+	_, err = os.Stat(filepath.Join(dir, "touched"))
+	assertion.Nil(err)
+
+	if assertion.Len(result.Commands, 1) && assertion.Len(result.Commands[0].Books, 1) {
+		host := result.Commands[0].Books[0].Hosts[0]
+		assertion.Equal(StatusOK, host.Status)
+		assertion.Equal(0, host.ExitStatus)
+		assertion.Equal(1, host.Attempts)
+		assertion.Equal(int64(len("touched\n")), host.Stdout)
+		assertion.Equal(int64(0), host.Stderr)
+		assertion.False(host.End.Before(host.Start))
+	}
+}
+
+func Test_PlayRunContextWithFailures(t *testing.T) {
+	assertion := assert.New(t)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name: "fail",
+		Run:  `echo failed >&2; [ "$PLAY_HOST" = 127.0.0.1 ] || exit 3`,
+	})
+	assertion.NotNil(err)
+	assertion.True(result.Failed())
+
+	failures, ok := err.(ErrFailures)
+	if assertion.True(ok) {
+		assertion.Equal(1, failures.Stats.OK)
+		assertion.Equal(1, failures.Stats.Failed)
+	}
+
+	hosts := result.Commands[0].Books[0].Hosts
+	assertion.Equal(StatusFailed, hosts[0].Status)
+	assertion.Equal(3, hosts[0].ExitStatus)
+	assertion.Equal(int64(len("failed\n")), hosts[0].Stderr)
+	assertion.Equal("failed\n", string(hosts[0].StderrTail))
+	assertion.NotNil(hosts[0].Err)
+	assertion.Equal(StatusOK, hosts[1].Status)
+}
+
+func Test_PlayRunContextWithCancel(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	network := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+
+	_, err = player.RunContext(ctx, network, nil, &Command{
+		Name: "sleep",
+		Run:  "sleep 10; echo slept",
+	}, &Command{
+		Name: "touch",
+		Run:  "touch " + filepath.Join(dir, "touched"),
+	})
+	assertion.Equal(context.DeadlineExceeded, err)
+	assertion.True(time.Since(started) < cancelGracePeriod)
+
+	// pending commands are not run
+	_, err = os.Stat(filepath.Join(dir, "touched"))
+	assertion.True(os.IsNotExist(err))
+
+	// cancelled before connecting
+	_, err = player.RunContext(ctx, network, nil, &Command{
+		Name: "touch",
+		Run:  "touch " + filepath.Join(dir, "touched"),
+	})
+	assertion.Equal(context.DeadlineExceeded, err)
+}
+
+func Test_PlayRunContextWithCommandTimeout(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	network := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	started := time.Now()
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name:      "sleep",
+		Run:       "sleep 10; echo slept",
+		Timeout:   200 * time.Millisecond,
+		OnFailure: FailureContinue,
+	}, &Command{
+		Name: "touch",
+		Run:  "touch " + filepath.Join(dir, "touched"),
+	})
+	assertion.Equal(ErrFailures{Stats{OK: 1, TimedOut: 1}}, err)
+	assertion.Equal(StatusTimedOut, result.Commands[0].Books[0].Hosts[0].Status)
+	assertion.True(time.Since(started) < cancelGracePeriod)
+
+	// next commands are run
+	_, err = os.Stat(filepath.Join(dir, "touched"))
+	assertion.Nil(err)
+}
+
+func Test_PlayRunContextWithFailurePolicy(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	logfile := filepath.Join(dir, "log")
+	failOnLocalhost := &Command{
+		Name: "upload",
+		Run:  `[ "$PLAY_HOST" = 127.0.0.1 ]`,
+	}
+	restart := &Command{
+		Name: "restart",
+		Run:  "echo $PLAY_HOST >> " + logfile,
+	}
+
+	// hosts failed drop out by default
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	result, err := player.RunContext(context.Background(), network, nil, failOnLocalhost, restart)
+	assertion.Equal(ErrFailures{Stats{OK: 2, Failed: 1, Skipped: 1}}, err)
+	assertion.Empty(result.Aborted)
+
+	hosts := result.Commands[1].Books[0].Hosts
+	assertion.Equal(StatusOK, hosts[0].Status)
+	assertion.Equal(StatusSkipped, hosts[1].Status)
+
+	data, err := ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("127.0.0.1\n", string(data))
+
+	// hosts failed keep running
+	assertion.Nil(os.Remove(logfile))
+
+	player, err = New(&Playfile{
+		OnFailure: FailureContinue,
+	})
+	assertion.Nil(err)
+
+	_, err = player.RunContext(context.Background(), network, nil, failOnLocalhost, restart)
+	assertion.Equal(ErrFailures{Stats{OK: 3, Failed: 1}}, err)
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "localhost")
+
+	// command overrides Playfile
+	abort := *failOnLocalhost
+	abort.OnFailure = FailureAbort
+
+	result, err = player.RunContext(context.Background(), network, nil, &abort, restart)
+	assertion.Equal(ErrFailures{Stats{OK: 1, Failed: 1}}, err)
+	assertion.Len(result.Commands, 1)
+	assertion.Contains(result.Aborted, "on_failure abort")
+
+	// abort once failed hosts exceed max fail percentage
+	player, err = New(&Playfile{
+		MaxFailPercentage: 40,
+	})
+	assertion.Nil(err)
+
+	result, err = player.RunContext(context.Background(), network, nil, failOnLocalhost, restart)
+	assertion.NotNil(err)
+	assertion.Len(result.Commands, 1)
+	assertion.Contains(result.Aborted, "max_fail_percentage 40%")
+
+	player, err = New(&Playfile{
+		MaxFailPercentage: 50,
+	})
+	assertion.Nil(err)
+
+	result, err = player.RunContext(context.Background(), network, nil, failOnLocalhost, restart)
+	assertion.NotNil(err)
+	assertion.Len(result.Commands, 2)
+	assertion.Empty(result.Aborted)
+}
+
+func Test_PlayRunContextWithRetries(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	network := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	// fails until the 3rd attempt
+	counter := filepath.Join(dir, "counter")
+	flaky := "n=$(cat " + counter + " 2>/dev/null || echo 0); n=$((n+1)); echo $n > " + counter + "; [ $n -ge 3 ]"
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name:       "flaky",
+		Run:        flaky,
+		Retries:    3,
+		RetryDelay: 10 * time.Millisecond,
+	})
+	assertion.Nil(err)
+	assertion.Equal(3, result.Commands[0].Books[0].Hosts[0].Attempts)
+
+	data, err := ioutil.ReadFile(counter)
+	assertion.Nil(err)
+	assertion.Equal("3\n", string(data))
+
+	// retries are exhausted
+	assertion.Nil(os.Remove(counter))
+
+	result, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name:       "flaky",
+		Run:        flaky,
+		Retries:    1,
+		RetryDelay: 10 * time.Millisecond,
+	})
+	assertion.Equal(ErrFailures{Stats{Failed: 1}}, err)
+	assertion.Equal(2, result.Commands[0].Books[0].Hosts[0].Attempts)
+	assertion.Equal(1, result.Commands[0].Books[0].Hosts[0].ExitStatus)
+
+	data, err = ioutil.ReadFile(counter)
+	assertion.Nil(err)
+	assertion.Equal("2\n", string(data))
+}
+
+func Test_PlayRunContextWithUploadRetries(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assertion.Nil(os.Mkdir(src, 0755))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(src, "app.conf"), []byte("port: 8080\n"), 0644))
+
+	network := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	for _, forks := range []int{0, 1} {
+		dst := filepath.Join(dir, fmt.Sprintf("dst%d", forks))
+		assertion.Nil(os.Mkdir(dst, 0755))
+
+		// destination is missing until the 2nd attempt
+		counter := filepath.Join(dir, fmt.Sprintf("counter%d", forks))
+		flaky := "$(n=$(cat " + counter + " 2>/dev/null || echo 0); n=$((n+1)); echo $n > " + counter + "; [ $n -ge 2 ] && echo " + dst + " || echo " + dst + "/missing)"
+
+		player, err := New(&Playfile{})
+		assertion.Nil(err)
+		player.Forks(forks)
+
+		result, err := player.RunContext(context.Background(), network, nil, &Command{
+			Name: "deploy",
+			Uploads: map[string]Upload{
+				"app": {Src: src, Dst: flaky},
+			},
+			Retries:    2,
+			RetryDelay: 10 * time.Millisecond,
+		})
+		assertion.Nil(err)
+		assertion.Equal(2, result.Commands[0].Books[0].Hosts[0].Attempts)
+
+		data, err := ioutil.ReadFile(filepath.Join(dst, src, "app.conf"))
+		assertion.Nil(err)
+		assertion.Equal("port: 8080\n", string(data))
+	}
+}
+
+func Test_PlayRunContextWithOnceFailover(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	// fails on localhost
+	hosts := filepath.Join(dir, "hosts")
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name: "migrate",
+		Run:  "echo $PLAY_HOST >> " + hosts + `; [ "$PLAY_HOST" = 127.0.0.1 ]`,
+		Once: OnceFirst,
+	})
+	assertion.Nil(err)
+	assertion.Equal(Stats{OK: 1, FailedOver: 1}, result.Stats())
+	assertion.Contains(result.Commands[0].OnceHost, "127.0.0.1")
+
+	data, err := ioutil.ReadFile(hosts)
+	assertion.Nil(err)
+	assertion.Equal("localhost\n127.0.0.1\n", string(data))
+
+	// runs on the first host only
+	assertion.Nil(os.Remove(hosts))
+
+	_, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name: "migrate",
+		Run:  "echo $PLAY_HOST >> " + hosts,
+		Once: OnceFirst,
+	})
+	assertion.Nil(err)
+
+	data, err = ioutil.ReadFile(hosts)
+	assertion.Nil(err)
+	assertion.Equal("localhost\n", string(data))
+}
+
+func Test_PlayRunContextWithForks(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	// fails if another host is running
+	lock := filepath.Join(dir, "lock")
+	exclusive := &Command{
+		Name: "exclusive",
+		Run:  "mkdir " + lock + " || exit 1; sleep 0.2; rmdir " + lock,
+	}
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	_, err = player.RunContext(context.Background(), network, nil, exclusive)
+	assertion.Equal(ErrFailures{Stats{OK: 1, Failed: 1}}, err)
+
+	player.Forks(1)
+
+	_, err = player.RunContext(context.Background(), network, nil, exclusive)
+	assertion.Nil(err)
+
+	// each host opens its own input
+	src := filepath.Join(dir, "src")
+	assertion.Nil(os.Mkdir(src, 0755))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(src, "app.conf"), []byte("forks"), 0644))
+
+	dst := filepath.Join(dir, "dst")
+	assertion.Nil(os.Mkdir(dst, 0755))
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name: "upload",
+		Uploads: map[string]Upload{
+			"app": {Src: src, Dst: dst},
+		},
+	})
+	assertion.Nil(err)
+	assertion.Equal(2, result.Stats().OK)
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, src, "app.conf"))
+	assertion.Nil(err)
+	assertion.Equal("forks", string(data))
+}
+
+func Test_PlayRunContextWithSerial(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	logfile := filepath.Join(dir, "log")
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	// each batch runs all books before the next batch
+	started := time.Now()
+
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name:                "deploy",
+		Run:                 "echo deploy $PLAY_HOST >> " + logfile,
+		Serial:              Serial{{Size: 50, Percent: true}},
+		PauseBetweenBatches: 100 * time.Millisecond,
+		HealthCheck:         "echo check $PLAY_HOST >> " + logfile,
+	})
+	assertion.Nil(err)
+	assertion.Len(result.Commands[0].Books, 4)
+	assertion.True(time.Since(started) >= 100*time.Millisecond)
+
+	data, err := ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("deploy localhost\ncheck localhost\ndeploy 127.0.0.1\ncheck 127.0.0.1\n", string(data))
+
+	// health check failed stops the rollout
+	assertion.Nil(os.Remove(logfile))
+
+	result, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name:        "deploy",
+		Run:         "echo deploy $PLAY_HOST >> " + logfile,
+		Serial:      Serial{{Size: 1}},
+		OnFailure:   FailureContinue,
+		HealthCheck: "false",
+	})
+	assertion.Equal(ErrFailures{Stats{OK: 1, Failed: 1}}, err)
+	assertion.Contains(result.Aborted, "health check of command deploy failed on 1 host(s) of batch 1/2")
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("deploy localhost\n", string(data))
+}
+
+func Test_PlayRunContextWithCanary(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	logfile := filepath.Join(dir, "log")
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	// canary promoted by check goes on with the rest
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name: "deploy",
+		Run:  "echo deploy $PLAY_HOST >> " + logfile,
+		Canary: &Canary{
+			Hosts: BatchSize{Size: 1},
+			Check: "echo check $PLAY_CANARY_HOSTS >> " + logfile,
+		},
+	})
+	assertion.Nil(err)
+	assertion.Len(result.Commands[0].Canary, 1)
+	assertion.True(result.Commands[0].Promoted)
+
+	data, err := ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("deploy localhost\ncheck "+result.Commands[0].Canary[0]+"\ndeploy 127.0.0.1\n", string(data))
+
+	// canary declined stops the rollout
+	assertion.Nil(os.Remove(logfile))
+
+	confirmFunc := confirm
+	defer func() {
+		confirm = confirmFunc
+	}()
+
+	var question string
+	confirm = func(s string) (bool, error) {
+		question = s
+
+		return false, nil
+	}
+
+	result, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name:   "deploy",
+		Run:    "echo deploy $PLAY_HOST >> " + logfile,
+		Canary: &Canary{Hosts: BatchSize{Size: 50, Percent: true}},
+	})
+	assertion.Equal(ErrCanaryDeclined{Name: "command deploy", Hosts: result.Commands[0].Canary}, err)
+	assertion.Contains(question, "promote to the rest 1 host(s)?")
+	assertion.False(result.Commands[0].Promoted)
+	assertion.Equal("canary of command deploy declined", result.Aborted)
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("deploy localhost\n", string(data))
+
+	// canary of run runs all commands on canary hosts first
+	assertion.Nil(os.Remove(logfile))
+
+	confirm = func(s string) (bool, error) {
+		return true, nil
+	}
+
+	player.Canary(&Canary{Hosts: BatchSize{Size: 1}})
+	defer player.Canary(nil)
+
+	result, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name: "build",
+		Run:  "echo build $PLAY_HOST >> " + logfile,
+	}, &Command{
+		Name: "deploy",
+		Run:  "echo deploy $PLAY_HOST >> " + logfile,
+	})
+	assertion.Nil(err)
+	assertion.True(result.Promoted)
+	assertion.Len(result.Commands, 4)
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("build localhost\ndeploy localhost\nbuild 127.0.0.1\ndeploy 127.0.0.1\n", string(data))
+
+	// canary failed aborts without promotion
+	assertion.Nil(os.Remove(logfile))
+
+	result, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name: "deploy",
+		Run:  "echo deploy $PLAY_HOST >> " + logfile + "; false",
+	})
+	assertion.Equal(ErrFailures{Stats{Failed: 1}}, err)
+	assertion.Equal("canary of run failed on 1 host(s)", result.Aborted)
+
+	// hosts unreachable are not of canary
+	assertion.Nil(os.Remove(logfile))
+
+	result, err = player.RunContext(context.Background(), &Network{
+		Hosts: []string{"localhost", "127.0.0.1", "nonexistent.invalid:1"},
+	}, nil, &Command{
+		Name: "deploy",
+		Run:  "echo deploy $PLAY_HOST >> " + logfile,
+	})
+	assertion.Equal(ErrFailures{Stats{OK: 2, Unreachable: 1}}, err)
+	assertion.Empty(result.Aborted)
+	assertion.True(result.Promoted)
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("deploy localhost\ndeploy 127.0.0.1\n", string(data))
+}
+
+func Test_PlayRunContextWithCheck(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	src := filepath.Join(dir, "src")
+	assertion.Nil(os.Mkdir(src, 0755))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(src, "app.conf"), []byte("port: 8080\n"), 0644))
+
+	dst := filepath.Join(dir, "dst")
+
+	cmd := &Command{
+		Name: "deploy",
+		Uploads: map[string]Upload{
+			"app": {Src: src, Dst: dst},
+		},
+		Run: "touch " + filepath.Join(dir, "restarted"),
+	}
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+	player.Check(true)
+
+	// files are new without destination
+	result, err := player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Nil(err)
+	assertion.Len(result.Commands[0].Books, 1)
+	assertion.True(result.Commands[0].Books[0].Hosts[0].Changed)
+
+	_, err = os.Stat(filepath.Join(dir, "restarted"))
+	assertion.True(os.IsNotExist(err))
+
+	_, err = os.Stat(dst)
+	assertion.True(os.IsNotExist(err))
+
+	// files are compared with remote ones
+	assertion.Nil(os.MkdirAll(filepath.Join(dst, src), 0755))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(dst, src, "app.conf"), []byte("port: 8080\n"), 0644))
+
+	result, err = player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Nil(err)
+	assertion.False(result.Commands[0].Books[0].Hosts[0].Changed)
+
+	assertion.Nil(os.Chmod(filepath.Join(src, "app.conf"), 0600))
+
+	result, err = player.RunContext(context.Background(), network, nil, cmd)
+	assertion.Nil(err)
+	assertion.True(result.Commands[0].Books[0].Hosts[0].Changed)
+}
+
+func Test_PlayRunContextWithHandlers(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	logfile := filepath.Join(dir, "log")
+
+	pfile := &Playfile{}
+	pfile.Handlers.Set("reload", Command{
+		Run:           "echo reload $PLAY_HOST >> " + logfile + "; echo CHANGED",
+		ChangedMarker: "CHANGED",
+		Notify:        []string{"notice"},
+	})
+	pfile.Handlers.Set("notice", Command{
+		Run: "echo notice $PLAY_HOST >> " + logfile,
+	})
+
+	player, err := New(pfile)
+	assertion.Nil(err)
+
+	// handlers run once on hosts changed only
+	result, err := player.RunContext(context.Background(), network, nil, &Command{
+		Name:          "config",
+		Run:           `[ "$PLAY_HOST" = localhost ] && echo CHANGED; true`,
+		ChangedMarker: "CHANGED",
+		Notify:        []string{"reload"},
+	}, &Command{
+		Name:            "migrate",
+		Run:             `[ "$PLAY_HOST" = localhost ] && exit 100; true`,
+		ChangedExitCode: 100,
+		Notify:          []string{"reload"},
+	})
+	assertion.Nil(err)
+	assertion.Len(result.Commands, 4)
+	assertion.True(result.Commands[2].Handler)
+	assertion.Equal("reload", result.Commands[2].Name)
+	assertion.Equal("notice", result.Commands[3].Name)
+	assertion.Equal(Stats{OK: 6}, result.Stats())
+
+	data, err := ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("reload localhost\nnotice localhost\n", string(data))
+
+	// uploads changed notify handlers
+	assertion.Nil(os.Remove(logfile))
+
+	src := filepath.Join(dir, "src")
+	assertion.Nil(os.Mkdir(src, 0755))
+	assertion.Nil(ioutil.WriteFile(filepath.Join(src, "app.conf"), []byte("port: 8080\n"), 0644))
+
+	dst := filepath.Join(dir, "dst")
+	assertion.Nil(os.Mkdir(dst, 0755))
+
+	upload := &Command{
+		Name: "upload",
+		Uploads: map[string]Upload{
+			"app": {Src: src, Dst: dst},
+		},
+		Notify: []string{"notice"},
+	}
+
+	// NOTE: hosts of network are the same one, uploads to dst must not race.
+	local := &Network{
+		Hosts: []string{"localhost"},
+	}
+
+	result, err = player.RunContext(context.Background(), local, nil, upload)
+	assertion.Nil(err)
+	assertion.Len(result.Commands, 2)
+
+	result, err = player.RunContext(context.Background(), local, nil, upload)
+	assertion.Nil(err)
+	assertion.Len(result.Commands, 1)
+	assertion.False(result.Commands[0].Books[0].Hosts[0].Changed)
+
+	// handlers must be defined
+	_, err = player.RunContext(context.Background(), network, nil, &Command{
+		Name:   "config",
+		Run:    "true",
+		Notify: []string{"restart"},
+	})
+	assertion.EqualError(err, "handler restart notified by command config does not exist")
+}
+
+func Test_PlayRunContextWithRollback(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	logfile := filepath.Join(dir, "log")
+
+	pfile := &Playfile{}
+	pfile.Commands.Set("undeploy", Command{
+		Run: "echo undeploy $PLAY_HOST >> " + logfile,
+	})
+
+	player, err := New(pfile)
+	assertion.Nil(err)
+
+	deploy := &Command{
+		Name:     "deploy",
+		Run:      `[ "$PLAY_HOST" != localhost ]`,
+		Rollback: "echo rollback $PLAY_HOST >> " + logfile,
+	}
+
+	// hosts failed are rolled back only
+	result, err := player.RunContext(context.Background(), network, nil, deploy)
+	assertion.Equal(ErrFailures{Stats{OK: 1, Failed: 1}}, err)
+	assertion.Len(result.Commands, 1)
+	assertion.Len(result.Rollbacks, 1)
+	assertion.Equal("deploy.rollback", result.Rollbacks[0].Name)
+	assertion.Equal("deploy", result.Rollbacks[0].Rollback)
+	assertion.Equal(Stats{OK: 1}, result.RollbackStats())
+
+	data, err := ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("rollback localhost\n", string(data))
+
+	// hosts succeeded are rolled back too with scope all
+	assertion.Nil(os.Remove(logfile))
+
+	deploy.Rollback = "undeploy"
+	deploy.RollbackScope = RollbackAll
+
+	result, err = player.RunContext(context.Background(), network, nil, deploy)
+	assertion.Equal(ErrFailures{Stats{OK: 1, Failed: 1}}, err)
+	assertion.Len(result.Rollbacks, 1)
+	assertion.Equal("undeploy", result.Rollbacks[0].Name)
+	assertion.Equal(Stats{OK: 2}, result.RollbackStats())
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "undeploy localhost\n")
+	assertion.Contains(string(data), "undeploy 127.0.0.1\n")
+
+	// nothing is rolled back if command succeeded
+	assertion.Nil(os.Remove(logfile))
+
+	deploy.Run = "true"
+
+	result, err = player.RunContext(context.Background(), network, nil, deploy)
+	assertion.Nil(err)
+	assertion.Empty(result.Rollbacks)
+
+	_, err = os.Stat(logfile)
+	assertion.True(os.IsNotExist(err))
+
+	// failures of rollback are reported separately
+	deploy.Run = "false"
+	deploy.Rollback = "false"
+	deploy.RollbackScope = ""
+
+	result, err = player.RunContext(context.Background(), network, nil, deploy)
+	assertion.Equal(ErrFailures{Stats{Failed: 2}}, err)
+	assertion.Equal(Stats{Failed: 2}, result.RollbackStats())
+}
+
+func Test_PlayRunContextWithState(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	network := &Network{
+		Hosts: []string{"localhost", "127.0.0.1"},
+	}
+
+	logfile := filepath.Join(dir, "log")
+	okfile := filepath.Join(dir, "ok")
+
+	pfile := &Playfile{}
+	pfile.Handlers.Set("reload", Command{
+		Run: "echo reload $PLAY_HOST >> " + logfile,
+	})
+
+	commands := []*Command{
+		{
+			Name:          "config",
+			Run:           "echo config $PLAY_HOST >> " + logfile + "; echo CHANGED",
+			ChangedMarker: "CHANGED",
+			Notify:        []string{"reload"},
+		},
+		{
+			Name: "migrate",
+			Run:  `[ "$PLAY_HOST" != localhost ] || [ -f ` + okfile + ` ] && echo migrate $PLAY_HOST >> ` + logfile,
+		},
+	}
+
+	state := NewRunState(dir, "local", []string{"config", "migrate"})
+
+	player, err := New(pfile)
+	assertion.Nil(err)
+	player.State(state)
+
+	// progress is saved after each book, handlers failed to run are kept
+	_, err = player.RunContext(context.Background(), network, nil, commands...)
+	assertion.Equal(ErrFailures{Stats{OK: 4, Failed: 1, Skipped: 1}}, err)
+
+	resumed, err := LoadRunState(dir, state.ID)
+	assertion.Nil(err)
+	assertion.Len(resumed.Steps, 3)
+	assertion.Equal(map[string][]string{"reload": {"root@localhost"}}, resumed.Notified)
+
+	data, err := ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.NotContains(string(data), "migrate localhost")
+	assertion.NotContains(string(data), "reload localhost")
+
+	// books completed on hosts are skipped for resuming
+	assertion.Nil(os.Remove(logfile))
+	assertion.Nil(ioutil.WriteFile(okfile, nil, 0644))
+
+	player, err = New(pfile)
+	assertion.Nil(err)
+	player.State(resumed)
+
+	result, err := player.RunContext(context.Background(), network, nil, commands...)
+	assertion.Nil(err)
+	assertion.Equal(Stats{OK: 2}, result.Stats())
+	assertion.Empty(resumed.Notified)
+
+	data, err = ioutil.ReadFile(logfile)
+	assertion.Nil(err)
+	assertion.Equal("migrate localhost\nreload localhost\n", string(data))
+}
+
+func Test_PlayCreateBooksWithEnv(t *testing.T) {
+	assertion := assert.New(t)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+
+	networkEnvs := EnvVars{{Key: "APP", Value: "web"}, {Key: "PORT", Value: "80"}}
+	cmdEnvs := EnvVars{{Key: "APP", Value: "web"}, {Key: "PORT", Value: "8080"}, {Key: "MODE", Value: "blue"}}
+
+	client := NewLocalClient(networkEnvs.AsExport())
+	client.Connect("localhost")
+
+	// env vars exported by clients are not exported again
+	books, err := player.createBooks([]Client{client}, &Command{Run: "echo $APP"}, networkEnvs, networkEnvs)
+	assertion.Nil(err)
+	assertion.Equal(1, len(books))
+	assertion.Empty(books[0].env)
+
+	books, err = player.createBooks([]Client{client}, &Command{Run: "echo $APP", HealthCheck: "true", Serial: Serial{{Size: 1}}}, cmdEnvs, networkEnvs)
+	assertion.Nil(err)
+	assertion.Equal(2, len(books))
+	for _, book := range books {
+		assertion.Equal(`export PORT="8080"; export MODE="blue"; `, book.env)
+	}
+}
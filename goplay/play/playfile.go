@@ -8,7 +8,9 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v2"
@@ -16,47 +18,289 @@ import (
 
 // Playfile represents the play configuration YAML file.
 type Playfile struct {
-	Version  string   `yaml:"version"`
-	Envs     EnvVars  `yaml:"envs"`
-	Networks Networks `yaml:"networks"`
-	Commands Commands `yaml:"commands"`
-	Books    Books    `yaml:"books"`
+	Version  string   `yaml:"version,omitempty"`
+	EnvFile  EnvFiles `yaml:"env_file,omitempty"`
+	Envs     EnvVars  `yaml:"envs,omitempty"`
+	Networks Networks `yaml:"networks,omitempty"`
+	Commands Commands `yaml:"commands,omitempty"`
+	Handlers Commands `yaml:"handlers,omitempty"` // Commands run only on hosts notified, see Command.Notify.
+	Books    Books    `yaml:"books,omitempty"`
+
+	OnFailure         FailurePolicy `yaml:"on_failure,omitempty"`          // Default policy of commands on host failures, see FailurePolicy.
+	MaxFailPercentage Percentage    `yaml:"max_fail_percentage,omitempty"` // Run is aborted once failed hosts exceed it, 0 means no limit.
+
+	dir     string // Dir of Playfile, relative env files are resolved against it.
+	format  string // Format of Playfile, see DetectFormat.
+	profile string // Profile overlaying Playfile, see NewPlayfileWithProfile.
+	source  []byte // YAML Playfile is parsed from, it's edited by Marshal.
+	vault   *Vault
 }
 
 // NewPlayfile parses configuration file and returns Playfile or error.
+// Format of data is detected by its content, see DetectFormat.
 func NewPlayfile(data []byte) (*Playfile, error) {
+	return NewPlayfileWithFormat(data, DetectFormat("", data))
+}
+
+// NewPlayfileWithFormat parses configuration file of format given and returns
+// Playfile or error. JSON and TOML are converted to YAML with order of keys
+// preserved, thus they share the same semantics.
+func NewPlayfileWithFormat(data []byte, format string) (*Playfile, error) {
+	data, err := toYAML(data, format)
+	if err != nil {
+		return nil, err
+	}
+
 	var config Playfile
 
-	err := yaml.Unmarshal(bytes.Replace(data, []byte("\t"), []byte("  "), -1), &config)
+	err = yaml.Unmarshal(data, &config)
 	if err != nil {
 		return nil, err
 	}
+	config.format = format
+	if format == FormatYAML {
+		config.source = data
+	}
 
 	return &config, nil
 }
 
-// NewPlayfileFromFile returns *Playfile by parsing filename given or error
+// NewPlayfileFromFile returns *Playfile by parsing filename given or error.
+// Format of file is detected by its extension, or its content if unknown.
 func NewPlayfileFromFile(filename string) (*Playfile, error) {
 	data, err := ioutil.ReadFile(path.Clean(filename))
 	if err != nil {
 		return nil, err
 	}
 
-	return NewPlayfile(data)
+	config, err := NewPlayfileWithFormat(data, DetectFormat(filename, data))
+	if err != nil {
+		return nil, err
+	}
+
+	config.dir, err = filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Marshal returns YAML of Playfile, order of networks, commands, books and
+// env vars is preserved, and values encrypted by vault are tagged with
+// VaultTag. YAML Playfile is parsed from is edited in place, its comments,
+// anchors and aliases of values unchanged are kept, see editYAML.
+func (p *Playfile) Marshal() ([]byte, error) {
+	data, err := p.marshalYAML()
+	if err != nil || p.source == nil {
+		return data, err
+	}
+
+	source, err := NewPlayfileWithFormat(p.source, FormatYAML)
+	if err != nil {
+		return nil, err
+	}
+
+	old, err := source.marshalYAML()
+	if err != nil {
+		return nil, err
+	}
+
+	return editYAML(p.source, old, data)
+}
+
+// marshalYAML returns YAML of Playfile without its source.
+func (p *Playfile) marshalYAML() ([]byte, error) {
+	data, err := yaml.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte("---\n"), tagVaultValues(data)...), nil
+}
+
+// WriteFile writes YAML of Playfile to filename atomically, mode of the file
+// is kept if exists. Only Playfile of YAML without profile is supported.
+func (p *Playfile) WriteFile(filename string) error {
+	format := p.format
+	if format == "" {
+		format = DetectFormat(filename, nil)
+	}
+	if format != FormatYAML {
+		return errors.Wrapf(ErrNotSupported, "writing Playfile of %s", format)
+	}
+	if p.profile != "" {
+		return errors.Wrapf(ErrNotSupported, "writing Playfile merged with profile %s", p.profile)
+	}
+
+	data, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode()
+	}
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(filename), ".Playfile-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	_, err = tmpfile.Write(data)
+	if err == nil {
+		err = tmpfile.Chmod(mode)
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpfile.Name(), filename)
+}
+
+// SetVault sets vault for decrypting env vars encrypted.
+func (p *Playfile) SetVault(vault *Vault) {
+	p.vault = vault
+}
+
+// EnvsFor returns env vars for running cmd on network. Env vars are layered
+// in order of precedence, the later overrides the former:
+//
+//	Playfile env_file < Playfile envs <
+//	network env_file < network env <
+//	command env_file < command env < overrides
+//
+// Values defined by Playfile, network and command are resolved by bash all
+// together, so late variables can reference early ones. Values loaded from
+// env files are used as is, but they can be referenced. Values encrypted by
+// vault are decrypted after resolving, they can't reference others and can't
+// be referenced by others. Overrides, which are usually given by CLI --env
+// flags, are used as is. Both network and cmd can be nil.
+func (p *Playfile) EnvsFor(network *Network, cmd *Command, overrides EnvVars) (EnvVars, error) {
+	var (
+		layers []EnvVars
+		vault  *Vault
+	)
+	if p != nil {
+		fileEnvs, err := p.loadEnvFiles(p.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, fileEnvs, p.Envs)
+		vault = p.vault
+	}
+	if network != nil {
+		fileEnvs, err := p.loadEnvFiles(network.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, fileEnvs, network.Envs)
+	}
+	if cmd != nil {
+		fileEnvs, err := p.loadEnvFiles(cmd.EnvFile)
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, fileEnvs, cmd.Env)
+	}
+
+	// NOTE: always copy values, ResolveValues overwrites them in place.
+	var envs EnvVars
+	for _, layer := range layers {
+		for _, v := range layer {
+			envs.setVar(*v)
+		}
+	}
+
+	err := envs.ResolveValues()
+	if err != nil {
+		return nil, err
+	}
+
+	err = envs.Decrypt(vault)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range overrides {
+		envs.Set(v.Key, v.Value)
+	}
+
+	return envs, nil
+}
+
+// loadEnvFiles loads env vars from files in order, the later overrides the
+// former. Relative files are resolved against dir of Playfile.
+func (p *Playfile) loadEnvFiles(files EnvFiles) (envs EnvVars, err error) {
+	for _, file := range files {
+		if !filepath.IsAbs(file) && p != nil && p.dir != "" {
+			file = filepath.Join(p.dir, file)
+		}
+
+		fileEnvs, fileErr := LoadEnvFile(file)
+		if fileErr != nil {
+			err = errors.Wrapf(fileErr, "loading env file %s failed", file)
+			return
+		}
+
+		for _, v := range fileEnvs {
+			envs.setVar(*v)
+		}
+	}
+
+	return
 }
 
 // Network is group of hosts with extra custom env vars.
 type Network struct {
-	Envs      EnvVars  `yaml:"env"`
-	Hosts     []string `yaml:"hosts"`
-	Inventory string   `yaml:"inventory"`
-	Bastion   string   `yaml:"bastion"` // Jump host for the environment
+	Name      string   `yaml:"-"` // Network name.
+	EnvFile   EnvFiles `yaml:"env_file,omitempty"`
+	Envs      EnvVars  `yaml:"env,omitempty"`
+	Hosts     []string `yaml:"hosts,omitempty"`
+	HostsFrom string   `yaml:"hosts_from,omitempty"` // Set expression of networks, e.g. "all - db"
+	Extends   string   `yaml:"extends,omitempty"`    // Network to inherit settings from
+	Inventory string   `yaml:"inventory,omitempty"`
+	Bastion   string   `yaml:"bastion,omitempty"` // Jump host for the environment
 
 	// Should these live on Hosts too? We'd have to change []string to struct, even in Playfile.
-	User         string `yaml:"user"`
-	Passwd       string `yaml:"passwd"`
-	Port         int    `yaml:"port"`
-	IdentityFile string `yaml:"identity_file"`
+	User         string `yaml:"user,omitempty"`
+	Passwd       string `yaml:"passwd,omitempty"`
+	Port         int    `yaml:"port,omitempty"`
+	IdentityFile string `yaml:"identity_file,omitempty"`
+}
+
+// AddHost appends host to the network, it returns false if exists.
+func (n *Network) AddHost(host string) bool {
+	for _, h := range n.Hosts {
+		if h == host {
+			return false
+		}
+	}
+
+	n.Hosts = append(n.Hosts, host)
+
+	return true
+}
+
+// RemoveHost removes host from the network, it returns false if not exists.
+func (n *Network) RemoveHost(host string) bool {
+	for i, h := range n.Hosts {
+		if h == host {
+			n.Hosts = append(n.Hosts[:i:i], n.Hosts[i+1:]...)
+
+			return true
+		}
+	}
+
+	return false
 }
 
 // ParseInventory runs the inventory command, if provided, and appends
@@ -103,11 +347,12 @@ func (n Network) ParseInventory() ([]string, error) {
 // Networks is a list of user-defined networks
 type Networks struct {
 	Names []string
-	nets  map[string]Network
+	defs  map[string]Network // Networks as defined.
+	nets  map[string]Network // Networks with extends and hosts_from resolved.
 }
 
 func (n *Networks) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	err := unmarshal(&n.nets)
+	err := unmarshal(&n.defs)
 	if err != nil {
 		return err
 	}
@@ -123,33 +368,284 @@ func (n *Networks) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		n.Names[i] = item.Key.(string)
 	}
 
-	return nil
+	return n.resolve()
+}
+
+// MarshalYAML implements yaml.Marshaler, networks are marshaled in order
+// as defined, extends and hosts_from are kept without resolving.
+func (n Networks) MarshalYAML() (interface{}, error) {
+	items := make(yaml.MapSlice, 0, len(n.Names))
+	for _, name := range n.Names {
+		items = append(items, yaml.MapItem{
+			Key:   name,
+			Value: n.defs[name],
+		})
+	}
+
+	return items, nil
 }
 
+// Get returns network with extends and hosts_from resolved.
 func (n *Networks) Get(name string) (Network, bool) {
 	net, ok := n.nets[name]
+	if ok {
+		net.Name = name
+	}
+
 	return net, ok
 }
 
+// Definition returns network as defined, it's useful for editing.
+func (n *Networks) Definition(name string) (Network, bool) {
+	net, ok := n.defs[name]
+	if ok {
+		net.Name = name
+	}
+
+	return net, ok
+}
+
+// Set adds network definition with name given, or replaces it in place if
+// exists. All networks are resolved again, and nothing changes on failure.
+func (n *Networks) Set(name string, network Network) error {
+	defs := make(map[string]Network, len(n.defs)+1)
+	for key, value := range n.defs {
+		defs[key] = value
+	}
+
+	names := n.Names
+	if _, ok := defs[name]; !ok {
+		names = append(names[:len(names):len(names)], name)
+	}
+
+	network.Name = ""
+	defs[name] = network
+
+	return n.replace(names, defs)
+}
+
+// Delete removes network with name given. It fails if the network does not
+// exist or it is referenced by others.
+func (n *Networks) Delete(name string) error {
+	if _, ok := n.defs[name]; !ok {
+		return errors.Errorf("network %s does not exist", name)
+	}
+
+	defs := make(map[string]Network, len(n.defs))
+	for key, value := range n.defs {
+		if key != name {
+			defs[key] = value
+		}
+	}
+
+	return n.replace(removeName(n.Names, name), defs)
+}
+
+func (n *Networks) replace(names []string, defs map[string]Network) error {
+	networks := Networks{
+		Names: names,
+		defs:  defs,
+	}
+
+	err := networks.resolve()
+	if err != nil {
+		return err
+	}
+
+	*n = networks
+
+	return nil
+}
+
 // Upload represents file copy operation from localhost Src path to remote Dst
 // path of every host in a given Network.
 type Upload struct {
-	Src    string `yaml:"src"`
-	Dst    string `yaml:"dst"`
-	Filter string `yaml:"filter"`
+	Src    string `yaml:"src,omitempty"`
+	Dst    string `yaml:"dst,omitempty"`
+	Filter string `yaml:"filter,omitempty"`
 }
 
 // Command represents command(s) to be run remotely.
 type Command struct {
-	Name    string            `yaml:"-"`       // Command name.
-	Desc    string            `yaml:"desc"`    // Command description.
-	Run     string            `yaml:"run"`     // Command(s) to be run remotelly.
-	Script  string            `yaml:"script"`  // Load command(s) from script and run it remotelly.
-	Uploads map[string]Upload `yaml:"uploads"` // See Upload struct.
-	Serial  int               `yaml:"serial"`  // Max number of clients processing a book in parallel.
-	Locally bool              `yaml:"locally"` // Command(s) to be run locally.
-	Stdin   bool              `yaml:"stdin"`   // Attach localhost STDOUT to remote commands' STDIN?
-	Once    bool              `yaml:"once"`    // The command should be run "once" (randomly on one host only).
+	Name    string            `yaml:"-"`                  // Command name.
+	Desc    string            `yaml:"desc,omitempty"`     // Command description.
+	Run     string            `yaml:"run,omitempty"`      // Command(s) to be run remotelly.
+	Script  string            `yaml:"script,omitempty"`   // Load command(s) from script and run it remotelly.
+	EnvFile EnvFiles          `yaml:"env_file,omitempty"` // Command specific env files, see Playfile.EnvsFor.
+	Env     EnvVars           `yaml:"env,omitempty"`      // Command specific env vars, see Playfile.EnvsFor.
+	Uploads map[string]Upload `yaml:"uploads,omitempty"`  // See Upload struct.
+	Serial  Serial            `yaml:"serial,omitempty"`   // Sizes of batches running the command in turn, see Serial.
+	Locally bool              `yaml:"locally,omitempty"`  // Command(s) to be run locally.
+	Stdin   bool              `yaml:"stdin,omitempty"`    // Attach localhost STDOUT to remote commands' STDIN?
+	Once    Once              `yaml:"once,omitempty"`     // The command should be run "once" on one host only, see Once.
+	Timeout time.Duration     `yaml:"timeout,omitempty"`  // Max duration of running the command, e.g. 30s and 5m.
+
+	Template bool `yaml:"template,omitempty"` // Render run, script and health check as templates for each host, see HostContext.
+
+	Retries    int           `yaml:"retries,omitempty"`     // Max number of re-runs on host exited with non-zero status.
+	RetryDelay time.Duration `yaml:"retry_delay,omitempty"` // Delay before the first re-run, it's doubled for each re-run.
+
+	OnFailure         FailurePolicy `yaml:"on_failure,omitempty"`          // Overrides Playfile.OnFailure.
+	MaxFailPercentage Percentage    `yaml:"max_fail_percentage,omitempty"` // Overrides Playfile.MaxFailPercentage.
+
+	PauseBetweenBatches time.Duration `yaml:"pause_between_batches,omitempty"` // Pause before each batch but the first, see Serial.
+	HealthCheck         string        `yaml:"health_check,omitempty"`          // Command(s) run on hosts of each batch, the run is aborted if it fails.
+	Canary              *Canary       `yaml:"canary,omitempty"`                // Hosts running the command before the rest, see Canary.
+
+	Notify          []string `yaml:"notify,omitempty"`            // Handlers notified on hosts changed by the command, see Playfile.Handlers.
+	ChangedExitCode int      `yaml:"changed_exit_code,omitempty"` // Exit status of run meaning succeeded with changes, e.g. 100.
+	ChangedMarker   string   `yaml:"changed_marker,omitempty"`    // Output of run meaning changes, e.g. "CHANGED".
+
+	Rollback      string        `yaml:"rollback,omitempty"`       // Name of command, or command(s) run on hosts failed by the command.
+	RollbackScope RollbackScope `yaml:"rollback_scope,omitempty"` // Hosts rolled back, see RollbackScope.
+}
+
+// Once selects the host running command once, the command fails over to next
+// host if it fails on the host selected.
+type Once string
+
+// Supported selections of Once, true of YAML is the same as OnceRandom.
+const (
+	OnceFirst  Once = "first"  // Hosts in order of network.
+	OnceRandom Once = "random" // Hosts in random order.
+)
+
+func (o *Once) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		*o = ""
+
+	case bool:
+		*o = ""
+		if v {
+			*o = OnceRandom
+		}
+
+	case string:
+		switch Once(v) {
+		case OnceFirst, OnceRandom:
+			*o = Once(v)
+
+		default:
+			return errors.Errorf("invalid once %q, it must be true, false, first or random", v)
+		}
+
+	default:
+		return errors.Errorf("invalid once %v, it must be true, false, first or random", v)
+	}
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, OnceRandom is marshaled as true.
+func (o Once) MarshalYAML() (interface{}, error) {
+	if o == OnceRandom {
+		return true, nil
+	}
+
+	return string(o), nil
+}
+
+// FailurePolicy defines how the run goes on once a book failed on hosts.
+type FailurePolicy string
+
+// Supported policies of FailurePolicy, FailureSkipHost is the default.
+const (
+	FailureAbort    FailurePolicy = "abort"     // Stop the run after the book failed.
+	FailureContinue FailurePolicy = "continue"  // Keep running later books on hosts failed.
+	FailureSkipHost FailurePolicy = "skip_host" // Hosts failed drop out of later books and commands.
+)
+
+// IsValidFailurePolicy returns true if policy given is supported, empty is
+// valid for the default.
+func IsValidFailurePolicy(policy string) bool {
+	switch FailurePolicy(policy) {
+	case "", FailureAbort, FailureContinue, FailureSkipHost:
+		return true
+	}
+
+	return false
+}
+
+func (f *FailurePolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	if !IsValidFailurePolicy(value) {
+		return errors.Errorf("invalid on_failure %q, it must be abort, continue or skip_host", value)
+	}
+
+	*f = FailurePolicy(value)
+
+	return nil
+}
+
+// RollbackScope defines hosts rolled back once the command failed on hosts.
+type RollbackScope string
+
+// Supported scopes of RollbackScope, RollbackFailed is the default.
+const (
+	RollbackFailed RollbackScope = "failed" // Hosts failed by the command.
+	RollbackAll    RollbackScope = "all"    // Hosts failed, and hosts succeeded by the command.
+)
+
+// IsValidRollbackScope returns true if scope given is supported, empty is
+// valid for the default.
+func IsValidRollbackScope(scope string) bool {
+	switch RollbackScope(scope) {
+	case "", RollbackFailed, RollbackAll:
+		return true
+	}
+
+	return false
+}
+
+func (r *RollbackScope) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	if !IsValidRollbackScope(value) {
+		return errors.Errorf("invalid rollback_scope %q, it must be failed or all", value)
+	}
+
+	*r = RollbackScope(value)
+
+	return nil
+}
+
+// Percentage defines percentage between 0 and 100.
+type Percentage int
+
+func (p *Percentage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value int
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	if value < 0 || value > 100 {
+		return errors.Errorf("invalid percentage %d, it must be between 0 and 100", value)
+	}
+
+	*p = Percentage(value)
+
+	return nil
 }
 
 // Commands is a list of user-defined commands
@@ -178,19 +674,81 @@ func (c *Commands) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// MarshalYAML implements yaml.Marshaler, commands are marshaled in order.
+func (c Commands) MarshalYAML() (interface{}, error) {
+	items := make(yaml.MapSlice, 0, len(c.Names))
+	for _, name := range c.Names {
+		items = append(items, yaml.MapItem{
+			Key:   name,
+			Value: c.cmds[name],
+		})
+	}
+
+	return items, nil
+}
+
 func (c *Commands) Get(name string) (Command, bool) {
 	cmd, ok := c.cmds[name]
 	return cmd, ok
 }
 
+// Set adds command with name given, or replaces it in place if exists.
+func (c *Commands) Set(name string, cmd Command) {
+	if c.cmds == nil {
+		c.cmds = make(map[string]Command)
+	}
+
+	if _, ok := c.cmds[name]; !ok {
+		c.Names = append(c.Names, name)
+	}
+
+	cmd.Name = ""
+	c.cmds[name] = cmd
+}
+
+// Delete removes command with name given, it returns false if not exists.
+func (c *Commands) Delete(name string) bool {
+	if _, ok := c.cmds[name]; !ok {
+		return false
+	}
+
+	delete(c.cmds, name)
+	c.Names = removeName(c.Names, name)
+
+	return true
+}
+
 // Books is a list of user-defined books
 type Books struct {
-	Names []string
-	books map[string][]string
+	Names    []string
+	books    map[string][]string
+	canaries map[string]*Canary
+	locks    map[string]*Lock
+}
+
+// bookDef is definition of book, it's given by list of commands, or with
+// options, e.g. "{commands: [build, deploy], canary: 1, lock: true}".
+type bookDef struct {
+	Commands []string `yaml:"commands"`
+	Canary   *Canary  `yaml:"canary,omitempty"`
+	Lock     *Lock    `yaml:"lock,omitempty"`
+}
+
+func (b *bookDef) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	err := unmarshal(&b.Commands)
+	if err == nil {
+		return nil
+	}
+
+	type def bookDef
+
+	return unmarshal((*def)(b))
 }
 
 func (b *Books) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	err := unmarshal(&b.books)
+	var defs map[string]bookDef
+
+	err := unmarshal(&defs)
 	if err != nil {
 		return err
 	}
@@ -202,22 +760,115 @@ func (b *Books) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	}
 
 	b.Names = make([]string, len(items))
+	b.books = make(map[string][]string, len(items))
+	b.canaries = make(map[string]*Canary)
+	b.locks = make(map[string]*Lock)
 	for i, item := range items {
-		b.Names[i] = item.Key.(string)
+		name := item.Key.(string)
+
+		b.Names[i] = name
+		b.books[name] = defs[name].Commands
+		if defs[name].Canary != nil {
+			b.canaries[name] = defs[name].Canary
+		}
+
+		// lock is named with the book by default.
+		if lock := defs[name].Lock; lock != nil {
+			if lock.Name == "" {
+				if _, err := NewLock(name); err != nil {
+					return errors.Wrapf(err, "invalid lock of book %s", name)
+				}
+
+				lock.Name = name
+			}
+
+			b.locks[name] = lock
+		}
 	}
 
 	return nil
 }
 
+// MarshalYAML implements yaml.Marshaler, books are marshaled in order.
+func (b Books) MarshalYAML() (interface{}, error) {
+	items := make(yaml.MapSlice, 0, len(b.Names))
+	for _, name := range b.Names {
+		var value interface{} = b.books[name]
+		if b.canaries[name] != nil || b.locks[name] != nil {
+			value = bookDef{
+				Commands: b.books[name],
+				Canary:   b.canaries[name],
+				Lock:     b.locks[name],
+			}
+		}
+
+		items = append(items, yaml.MapItem{
+			Key:   name,
+			Value: value,
+		})
+	}
+
+	return items, nil
+}
+
+// Canary returns canary of book with name given, it's nil if not defined.
+func (b *Books) Canary(name string) *Canary {
+	return b.canaries[name]
+}
+
+// Lock returns lock of book with name given, it's nil if not defined.
+func (b *Books) Lock(name string) *Lock {
+	return b.locks[name]
+}
+
 func (b *Books) Get(name string) ([]string, bool) {
 	cmds, ok := b.books[name]
 	return cmds, ok
 }
 
+// Set adds book with name given, or replaces it in place if exists.
+func (b *Books) Set(name string, cmds []string) {
+	if b.books == nil {
+		b.books = make(map[string][]string)
+	}
+
+	if _, ok := b.books[name]; !ok {
+		b.Names = append(b.Names, name)
+	}
+
+	b.books[name] = cmds
+}
+
+// Delete removes book with name given, it returns false if not exists.
+func (b *Books) Delete(name string) bool {
+	if _, ok := b.books[name]; !ok {
+		return false
+	}
+
+	delete(b.books, name)
+	delete(b.canaries, name)
+	delete(b.locks, name)
+	b.Names = removeName(b.Names, name)
+
+	return true
+}
+
+func removeName(names []string, name string) []string {
+	for i, s := range names {
+		if s == name {
+			return append(names[:i:i], names[i+1:]...)
+		}
+	}
+
+	return names
+}
+
 // EnvVar represents an environment variable
 type EnvVar struct {
-	Key   string
-	Value string
+	Key     string
+	Value   string
+	Literal bool // Value is used as is without bash resolving, e.g. loaded from env file.
+	Secret  bool // Value is decrypted from vault, it must be masked in outputs.
 }
 
 func (e EnvVar) String() string {
@@ -226,6 +877,11 @@ func (e EnvVar) String() string {
 
 // AsExport returns the environment variable as a bash export statement
 func (e EnvVar) AsExport() string {
+	// NOTE: literal and secret are exported as is without any expansion.
+	if e.Literal || e.Secret {
+		return `export ` + e.Key + `='` + strings.Replace(e.Value, `'`, `'\''`, -1) + `';`
+	}
+
 	return `export ` + e.Key + `="` + e.Value + `";`
 }
 
@@ -242,6 +898,30 @@ func (e EnvVars) Slice() []string {
 	return envs
 }
 
+// Map returns env vars as a map of key to value.
+func (e EnvVars) Map() map[string]string {
+	envs := make(map[string]string, len(e))
+	for _, env := range e {
+		envs[env.Key] = env.Value
+	}
+
+	return envs
+}
+
+// MarshalYAML implements yaml.Marshaler, env vars are marshaled in order.
+// Values encrypted by vault are tagged by Playfile.Marshal.
+func (e EnvVars) MarshalYAML() (interface{}, error) {
+	items := make(yaml.MapSlice, 0, len(e))
+	for _, v := range e {
+		items = append(items, yaml.MapItem{
+			Key:   v.Key,
+			Value: v.Value,
+		})
+	}
+
+	return items, nil
+}
+
 func (e *EnvVars) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	items := []yaml.MapItem{}
 
@@ -264,6 +944,8 @@ func (e *EnvVars) Set(key, value string) {
 	for i, v := range *e {
 		if v.Key == key {
 			(*e)[i].Value = value
+			(*e)[i].Literal = false
+			(*e)[i].Secret = false
 			return
 		}
 	}
@@ -274,6 +956,37 @@ func (e *EnvVars) Set(key, value string) {
 	})
 }
 
+// setVar sets a copy of v in this list, including its flags.
+func (e *EnvVars) setVar(v EnvVar) {
+	for i, env := range *e {
+		if env.Key == v.Key {
+			*(*e)[i] = v
+			return
+		}
+	}
+
+	*e = append(*e, &v)
+}
+
+// diff returns env vars of e which base does not have the same of, e.g. env
+// vars of command layered over its network's.
+func (e EnvVars) diff(base EnvVars) EnvVars {
+	var envs EnvVars
+	for _, v := range e {
+		same := false
+		for _, b := range base {
+			if b.Key == v.Key {
+				same = *b == *v
+			}
+		}
+		if !same {
+			envs = append(envs, v)
+		}
+	}
+
+	return envs
+}
+
 func (e *EnvVars) ResolveValues() error {
 	if len(*e) == 0 {
 		return nil
@@ -281,8 +994,17 @@ func (e *EnvVars) ResolveValues() error {
 
 	exports := ""
 	for i, v := range *e {
+		// NOTE: values encrypted by vault are decrypted later, see Decrypt.
+		if v.Secret || IsVaultValue(v.Value) {
+			continue
+		}
+
 		exports += v.AsExport()
 
+		if v.Literal {
+			continue
+		}
+
 		cmd := exec.Command("bash", "-c", exports+"echo -n "+v.Value+";")
 		cwd, err := os.Getwd()
 		if err != nil {
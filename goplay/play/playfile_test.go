@@ -2,8 +2,10 @@ package play
 
 import (
 	"testing"
+	"time"
 
 	"github.com/golib/assert"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -118,3 +120,311 @@ func Test_NewPlayfile(t *testing.T) {
 		assertion.Equal([]string{"echo", "date"}, book)
 	}
 }
+
+func Test_PlayfileEnvsFor(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+envs:
+  NAME: goplay
+  SERVICE: app
+
+networks:
+  all:
+    env:
+      SERVICE: api
+    hosts:
+      - 127.0.0.1
+
+commands:
+  restart:
+    env:
+      SERVICE: $NAME-worker
+    run: echo $SERVICE
+`))
+	assertion.Nil(err)
+
+	network, _ := pfile.Networks.Get("all")
+	cmd, _ := pfile.Commands.Get("restart")
+
+	envs, err := pfile.EnvsFor(&network, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal([]string{"NAME=goplay", "SERVICE=api"}, envs.Slice())
+
+	envs, err = pfile.EnvsFor(&network, &cmd, nil)
+	assertion.Nil(err)
+	assertion.Equal([]string{"NAME=goplay", "SERVICE=goplay-worker"}, envs.Slice())
+
+	// overrides win and are used as is
+	envs, err = pfile.EnvsFor(&network, &cmd, EnvVars{{Key: "SERVICE", Value: "$NAME"}})
+	assertion.Nil(err)
+	assertion.Equal([]string{"NAME=goplay", "SERVICE=$NAME"}, envs.Slice())
+
+	// values of Playfile are never changed
+	assertion.Equal("$NAME-worker", cmd.Env[0].Value)
+}
+
+func Test_PlayfileMarshal(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(playfile))
+	assertion.Nil(err)
+
+	network, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.True(network.AddHost("127.0.0.2"))
+	assertion.False(network.AddHost("127.0.0.2"))
+	assertion.Nil(pfile.Networks.Set("db", network))
+	assertion.Nil(pfile.Networks.Set("cache", Network{Hosts: []string{"127.0.0.3"}}))
+	assertion.Nil(pfile.Networks.Delete("pfd"))
+	assertion.NotNil(pfile.Networks.Delete("pfd"))
+
+	pfile.Commands.Set("uptime", Command{Run: "uptime", Once: OnceRandom})
+	assertion.True(pfile.Commands.Delete("echo"))
+
+	pfile.Books.Set("all", []string{"date", "uptime"})
+
+	data, err := pfile.Marshal()
+	assertion.Nil(err)
+
+	saved, err := NewPlayfile(data)
+	assertion.Nil(err)
+	assertion.Equal("1.0.0", saved.Version)
+	assertion.Equal([]string{"all", "app", "db", "ebd", "cache"}, saved.Networks.Names)
+	assertion.Equal([]string{"date", "assets", "uptime"}, saved.Commands.Names)
+	assertion.Equal([]string{"all"}, saved.Books.Names)
+
+	network, ok = saved.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal("root", network.User)
+	assertion.Equal([]string{"127.0.0.1", "127.0.0.2"}, network.Hosts)
+
+	cmd, ok := saved.Commands.Get("uptime")
+	assertion.True(ok)
+	assertion.Equal("uptime", cmd.Run)
+	assertion.Equal(OnceRandom, cmd.Once)
+
+	cmd, ok = saved.Commands.Get("assets")
+	assertion.True(ok)
+	assertion.Equal(2, len(cmd.Uploads))
+
+	book, ok := saved.Books.Get("all")
+	assertion.True(ok)
+	assertion.Equal([]string{"date", "uptime"}, book)
+}
+
+func Test_CommandTimeout(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+commands:
+  upgrade:
+    run: apt-get upgrade -y
+    timeout: 5m30s
+`))
+	assertion.Nil(err)
+
+	cmd, ok := pfile.Commands.Get("upgrade")
+	assertion.True(ok)
+	assertion.Equal(5*time.Minute+30*time.Second, cmd.Timeout)
+
+	data, err := pfile.Marshal()
+	assertion.Nil(err)
+	assertion.Contains(string(data), "timeout: 5m30s")
+
+	_, err = NewPlayfile([]byte("---\nversion: 1.0.0\ncommands:\n  upgrade:\n    timeout: 5 minutes\n"))
+	assertion.NotNil(err)
+}
+
+func Test_CommandOnce(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+commands:
+  migrate:
+    run: ./migrate.sh
+    once: true
+  seed:
+    run: ./seed.sh
+    once: first
+  shuffle:
+    run: ./shuffle.sh
+    once: random
+  all:
+    run: ./all.sh
+    once: false
+`))
+	assertion.Nil(err)
+
+	for name, once := range map[string]Once{
+		"migrate": OnceRandom,
+		"seed":    OnceFirst,
+		"shuffle": OnceRandom,
+		"all":     "",
+	} {
+		cmd, ok := pfile.Commands.Get(name)
+		assertion.True(ok)
+		assertion.Equal(once, cmd.Once, name)
+	}
+
+	data, err := pfile.Marshal()
+	assertion.Nil(err)
+	assertion.Contains(string(data), "once: true")
+	assertion.Contains(string(data), "once: first")
+
+	_, err = NewPlayfile([]byte("---\nversion: 1.0.0\ncommands:\n  migrate:\n    once: last\n"))
+	assertion.NotNil(err)
+}
+
+func Test_CommandFailurePolicy(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+on_failure: continue
+max_fail_percentage: 30
+
+commands:
+  upload:
+    run: ./upload.sh
+    on_failure: skip_host
+  restart:
+    run: ./restart.sh
+    max_fail_percentage: 10
+`))
+	assertion.Nil(err)
+	assertion.Equal(FailureContinue, pfile.OnFailure)
+	assertion.Equal(Percentage(30), pfile.MaxFailPercentage)
+
+	player, err := New(pfile)
+	assertion.Nil(err)
+
+	upload, _ := pfile.Commands.Get("upload")
+	policy, maxFail := player.failurePolicy(&upload)
+	assertion.Equal(FailureSkipHost, policy)
+	assertion.Equal(Percentage(30), maxFail)
+
+	restart, _ := pfile.Commands.Get("restart")
+	policy, maxFail = player.failurePolicy(&restart)
+	assertion.Equal(FailureContinue, policy)
+	assertion.Equal(Percentage(10), maxFail)
+
+	_, err = NewPlayfile([]byte("---\nversion: 1.0.0\non_failure: ignore\n"))
+	assertion.NotNil(err)
+
+	_, err = NewPlayfile([]byte("---\nversion: 1.0.0\nmax_fail_percentage: 101\n"))
+	assertion.NotNil(err)
+}
+
+func Test_CommandRollback(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+commands:
+  deploy:
+    run: ./deploy.sh
+    rollback: undeploy
+    rollback_scope: all
+  undeploy:
+    run: ./undeploy.sh
+`))
+	assertion.Nil(err)
+
+	deploy, _ := pfile.Commands.Get("deploy")
+	assertion.Equal("undeploy", deploy.Rollback)
+	assertion.Equal(RollbackAll, deploy.RollbackScope)
+
+	_, err = NewPlayfile([]byte("---\nversion: 1.0.0\ncommands:\n  deploy:\n    run: ./deploy.sh\n    rollback_scope: succeeded\n"))
+	assertion.NotNil(err)
+}
+
+func Test_PlayfileMarshalWithSource(t *testing.T) {
+	assertion := assert.New(t)
+
+	pfile, err := NewPlayfile([]byte(playfile))
+	assertion.Nil(err)
+
+	network, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.True(network.AddHost("127.0.0.2"))
+	assertion.Nil(pfile.Networks.Set("db", network))
+	assertion.Nil(pfile.Networks.Delete("pfd"))
+
+	pfile.Commands.Set("uptime", Command{Run: "uptime"})
+	assertion.True(pfile.Commands.Delete("echo"))
+
+	data, err := pfile.Marshal()
+	assertion.Nil(err)
+	assertion.Contains(string(data), `
+# Global variables
+user: &user
+  user: root
+  identity_file: ~/.ssh/testing_rsa
+
+all_hosts: &all_hosts
+  <<: *user
+`)
+	assertion.Contains(string(data), `
+  app:
+    <<: *app_hosts
+
+  db:
+    <<: *db_hosts
+    hosts:
+      - 127.0.0.1
+      - 127.0.0.2
+
+  ebd:
+    <<: *ebd_hosts
+`)
+	assertion.Contains(string(data), `
+        dst: /home/deploy/.bash_profile
+
+  uptime:
+    run: uptime
+`)
+	assertion.NotContains(string(data), "  pfd:")
+	assertion.NotContains(string(data), "echo $PLAY_NETWORK")
+
+	saved, err := NewPlayfile(data)
+	assertion.Nil(err)
+	assertion.Equal([]string{"all", "app", "db", "ebd"}, saved.Networks.Names)
+	assertion.Equal([]string{"date", "assets", "uptime"}, saved.Commands.Names)
+
+	network, ok = saved.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal("root", network.User)
+	assertion.Equal([]string{"127.0.0.1", "127.0.0.2"}, network.Hosts)
+
+	network, ok = saved.Networks.Get("app")
+	assertion.True(ok)
+	assertion.Equal("root", network.User)
+	assertion.Equal([]string{"127.0.0.1"}, network.Hosts)
+
+	// editing value referenced by aliases
+	pfile, err = NewPlayfile([]byte(`---
+networks:
+  app:
+    hosts: &hosts
+      - 127.0.0.1
+  db:
+    hosts: *hosts
+`))
+	assertion.Nil(err)
+
+	network, ok = pfile.Networks.Get("app")
+	assertion.True(ok)
+	assertion.True(network.AddHost("127.0.0.2"))
+	assertion.Nil(pfile.Networks.Set("app", network))
+
+	_, err = pfile.Marshal()
+	assertion.Equal(ErrNotSupported, errors.Cause(err))
+}
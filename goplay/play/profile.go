@@ -0,0 +1,138 @@
+package play
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// ProfileFilename returns filename of profile overlaying Playfile given, e.g.
+// Playfile.staging.yml of Playfile.yml with profile staging.
+func ProfileFilename(filename, profile string) string {
+	ext := filepath.Ext(filename)
+
+	return strings.TrimSuffix(filename, ext) + "." + profile + ext
+}
+
+// NewPlayfileWithProfile returns *Playfile by parsing filename given, and the
+// profile file overlaying it, see ProfileFilename. The profile is optional,
+// it's the same as NewPlayfileFromFile if profile is empty.
+//
+// The profile is deep-merged with Playfile before decoding:
+//
+//	maps, e.g. networks, commands and env vars, are merged by key recursively
+//	lists and scalars, e.g. hosts, env_file and run, are replaced
+//	keys of null, e.g. "inventory: ~", are removed
+//
+// Keys merged keep their position of Playfile, new keys are appended in order
+// of the profile.
+func NewPlayfileWithProfile(filename, profile string) (*Playfile, error) {
+	if profile == "" {
+		return NewPlayfileFromFile(filename)
+	}
+
+	base, format, err := readYAMLDocument(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	overlayFilename := ProfileFilename(filename, profile)
+
+	overlay, _, err := readYAMLDocument(overlayFilename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading profile %s failed", profile)
+	}
+
+	data, err := yaml.Marshal(mergeYAMLDocument(base, overlay))
+	if err != nil {
+		return nil, err
+	}
+
+	var config Playfile
+
+	err = yaml.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "merging profile %s failed", profile)
+	}
+	config.format = format
+	config.profile = profile
+
+	config.dir, err = filepath.Abs(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Profile returns name of profile overlaying Playfile, it's empty if none.
+func (p *Playfile) Profile() string {
+	return p.profile
+}
+
+func readYAMLDocument(filename string) (doc yaml.MapSlice, format string, err error) {
+	data, err := ioutil.ReadFile(path.Clean(filename))
+	if err != nil {
+		return
+	}
+
+	format = DetectFormat(filename, data)
+
+	data, err = toYAML(data, format)
+	if err != nil {
+		return
+	}
+
+	err = yaml.Unmarshal(data, &doc)
+	if err != nil {
+		err = errors.Wrapf(err, "parsing %s failed", filename)
+	}
+
+	return
+}
+
+// mergeYAMLDocument returns a copy of base with overlay merged, see
+// NewPlayfileWithProfile for rules.
+func mergeYAMLDocument(base, overlay yaml.MapSlice) yaml.MapSlice {
+	merged := make(yaml.MapSlice, 0, len(base)+len(overlay))
+	merged = append(merged, base...)
+
+	for _, item := range overlay {
+		i := indexOfYAMLKey(merged, item.Key)
+
+		switch {
+		case item.Value == nil:
+			if i >= 0 {
+				merged = append(merged[:i:i], merged[i+1:]...)
+			}
+
+		case i < 0:
+			merged = append(merged, item)
+
+		default:
+			baseMap, baseOK := merged[i].Value.(yaml.MapSlice)
+			overlayMap, overlayOK := item.Value.(yaml.MapSlice)
+			if baseOK && overlayOK {
+				merged[i].Value = mergeYAMLDocument(baseMap, overlayMap)
+			} else {
+				merged[i].Value = item.Value
+			}
+		}
+	}
+
+	return merged
+}
+
+func indexOfYAMLKey(items yaml.MapSlice, key interface{}) int {
+	for i, item := range items {
+		if item.Key == key {
+			return i
+		}
+	}
+
+	return -1
+}
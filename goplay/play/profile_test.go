@@ -0,0 +1,118 @@
+package play
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_ProfileFilename(t *testing.T) {
+	assertion := assert.New(t)
+
+	assertion.Equal("/etc/Playfile.staging.yml", ProfileFilename("/etc/Playfile.yml", "staging"))
+	assertion.Equal("Playfile.prod", ProfileFilename("Playfile", "prod"))
+}
+
+func Test_NewPlayfileWithProfile(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "Playfile.yml")
+
+	assertion.Nil(ioutil.WriteFile(filename, []byte(`---
+version: 1.0.0
+
+envs:
+  NAME: goplay
+  REGION: cn
+
+networks:
+  all:
+    user: root
+    inventory: echo 10.0.0.9
+    hosts:
+      - 10.0.0.1
+      - 10.0.0.2
+  db:
+    extends: all
+    hosts:
+      - 10.0.0.2
+
+commands:
+  deploy:
+    desc: Deploy app
+    run: ./deploy.sh
+    once: true
+    env:
+      TAG: latest
+`), 0644))
+
+	assertion.Nil(ioutil.WriteFile(filepath.Join(dir, "Playfile.staging.yml"), []byte(`---
+envs:
+  REGION: us
+  DEBUG: true
+
+networks:
+  all:
+    user: deploy
+    inventory: ~
+    hosts:
+      - 10.1.0.1
+  web:
+    extends: all
+
+commands:
+  deploy:
+    once: false
+    env:
+      TAG: staging
+`), 0644))
+
+	pfile, err := NewPlayfileWithProfile(filename, "staging")
+	assertion.Nil(err)
+	assertion.Equal("staging", pfile.Profile())
+	assertion.Equal("1.0.0", pfile.Version)
+	assertion.Equal([]string{"NAME=goplay", "REGION=us", "DEBUG=true"}, pfile.Envs.Slice())
+	assertion.Equal([]string{"all", "db", "web"}, pfile.Networks.Names)
+
+	all, ok := pfile.Networks.Get("all")
+	assertion.True(ok)
+	assertion.Equal("deploy", all.User)
+	assertion.Empty(all.Inventory)
+	assertion.Equal([]string{"10.1.0.1"}, all.Hosts)
+
+	db, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal("deploy", db.User)
+	assertion.Equal([]string{"10.0.0.2"}, db.Hosts)
+
+	web, ok := pfile.Networks.Get("web")
+	assertion.True(ok)
+	assertion.Equal([]string{"10.1.0.1"}, web.Hosts)
+
+	deploy, ok := pfile.Commands.Get("deploy")
+	assertion.True(ok)
+	assertion.Equal("Deploy app", deploy.Desc)
+	assertion.Equal("./deploy.sh", deploy.Run)
+	assertion.Empty(deploy.Once)
+	assertion.Equal([]string{"TAG=staging"}, deploy.Env.Slice())
+
+	// Playfile merged can't be written back
+	assertion.NotNil(pfile.WriteFile(filename))
+
+	// without profile
+	pfile, err = NewPlayfileWithProfile(filename, "")
+	assertion.Nil(err)
+	assertion.Empty(pfile.Profile())
+	assertion.Equal([]string{"NAME=goplay", "REGION=cn"}, pfile.Envs.Slice())
+
+	// profile file is required
+	_, err = NewPlayfileWithProfile(filename, "prod")
+	assertion.NotNil(err)
+}
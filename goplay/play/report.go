@@ -0,0 +1,413 @@
+package play
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Supported formats of run report.
+const (
+	ReportJSON  = "json"
+	ReportJUnit = "junit"
+)
+
+// IsValidReportFormat returns true if format given is supported by WriteReport.
+func IsValidReportFormat(format string) bool {
+	switch format {
+	case ReportJSON, ReportJUnit:
+		return true
+	}
+
+	return false
+}
+
+// WriteReport writes report of result in format given to w, see ReportJSON
+// and ReportJUnit. Each book on each host is reported as a test case of JUnit,
+// which is grouped by command.
+func WriteReport(w io.Writer, format string, result *Result) error {
+	switch format {
+	case ReportJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		return encoder.Encode(newJSONReport(result))
+
+	case ReportJUnit:
+		_, err := io.WriteString(w, xml.Header)
+		if err != nil {
+			return err
+		}
+
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+
+		err = encoder.Encode(newJUnitReport(result))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.WriteString(w, "\n")
+		return err
+	}
+
+	return ErrNotSupported
+}
+
+type jsonReport struct {
+	Network       string               `json:"network"`
+	Start         time.Time            `json:"start"`
+	End           time.Time            `json:"end"`
+	Duration      float64              `json:"duration"`
+	Stats         jsonStats            `json:"stats"`
+	Unreachable   []*jsonHostReport    `json:"unreachable"`
+	Commands      []*jsonCommandReport `json:"commands"`
+	Aborted       string               `json:"aborted,omitempty"`
+	Canary        []string             `json:"canary,omitempty"`
+	Promoted      bool                 `json:"promoted,omitempty"`
+	Rollbacks     []*jsonCommandReport `json:"rollbacks,omitempty"`
+	RollbackStats *jsonStats           `json:"rollback_stats,omitempty"`
+}
+
+type jsonStats struct {
+	OK          int `json:"ok"`
+	Failed      int `json:"failed"`
+	FailedOver  int `json:"failed_over"`
+	TimedOut    int `json:"timed_out"`
+	Cancelled   int `json:"cancelled"`
+	Unreachable int `json:"unreachable"`
+	Skipped     int `json:"skipped"`
+	Errors      int `json:"errors"`
+}
+
+type jsonCommandReport struct {
+	Name     string            `json:"name"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Duration float64           `json:"duration"`
+	OnceHost string            `json:"once_host,omitempty"`
+	Handler  bool              `json:"handler,omitempty"`
+	Lock     bool              `json:"lock,omitempty"`
+	Rollback string            `json:"rollback,omitempty"`
+	Canary   []string          `json:"canary,omitempty"`
+	Promoted bool              `json:"promoted,omitempty"`
+	Books    []*jsonBookReport `json:"books"`
+	Error    string            `json:"error,omitempty"`
+}
+
+type jsonBookReport struct {
+	Run   string            `json:"run"`
+	Hosts []*jsonHostReport `json:"hosts"`
+}
+
+type jsonHostReport struct {
+	Host       string    `json:"host"`
+	Status     Status    `json:"status"`
+	ExitStatus int       `json:"exit_status"`
+	Attempts   int       `json:"attempts"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Duration   float64   `json:"duration"`
+	Stdout     int64     `json:"stdout_bytes"`
+	Stderr     int64     `json:"stderr_bytes"`
+	StderrTail string    `json:"stderr,omitempty"`
+	Changed    bool      `json:"changed,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+func newJSONReport(result *Result) *jsonReport {
+	stats := result.Stats()
+
+	report := &jsonReport{
+		Network:     result.Network,
+		Start:       result.Start,
+		End:         result.End,
+		Duration:    result.End.Sub(result.Start).Seconds(),
+		Stats:       jsonStats(stats),
+		Unreachable: []*jsonHostReport{},
+		Commands:    []*jsonCommandReport{},
+		Aborted:     result.Aborted,
+		Canary:      result.Canary,
+		Promoted:    result.Promoted,
+	}
+
+	for _, host := range result.Unreachable {
+		report.Unreachable = append(report.Unreachable, newJSONHostReport(host))
+	}
+
+	for _, cmd := range result.Commands {
+		report.Commands = append(report.Commands, newJSONCommandReport(cmd))
+	}
+
+	if len(result.Rollbacks) > 0 {
+		rollbackStats := jsonStats(result.RollbackStats())
+
+		report.RollbackStats = &rollbackStats
+		for _, cmd := range result.Rollbacks {
+			report.Rollbacks = append(report.Rollbacks, newJSONCommandReport(cmd))
+		}
+	}
+
+	return report
+}
+
+func newJSONCommandReport(cmd *CommandResult) *jsonCommandReport {
+	report := &jsonCommandReport{
+		Name:     cmd.Name,
+		Start:    cmd.Start,
+		End:      cmd.End,
+		Duration: cmd.End.Sub(cmd.Start).Seconds(),
+		OnceHost: cmd.OnceHost,
+		Handler:  cmd.Handler,
+		Lock:     cmd.Lock,
+		Rollback: cmd.Rollback,
+		Canary:   cmd.Canary,
+		Promoted: cmd.Promoted,
+		Books:    []*jsonBookReport{},
+		Error:    errorString(cmd.Err),
+	}
+
+	for _, book := range cmd.Books {
+		bookReport := &jsonBookReport{
+			Run:   book.Run,
+			Hosts: []*jsonHostReport{},
+		}
+
+		for _, host := range book.Hosts {
+			bookReport.Hosts = append(bookReport.Hosts, newJSONHostReport(host))
+		}
+
+		report.Books = append(report.Books, bookReport)
+	}
+
+	return report
+}
+
+func newJSONHostReport(host *HostResult) *jsonHostReport {
+	return &jsonHostReport{
+		Host:       host.Host,
+		Status:     host.Status,
+		ExitStatus: host.ExitStatus,
+		Attempts:   host.Attempts,
+		Start:      host.Start,
+		End:        host.End,
+		Duration:   host.End.Sub(host.Start).Seconds(),
+		Stdout:     host.Stdout,
+		Stderr:     host.Stderr,
+		StderrTail: string(host.StderrTail),
+		Changed:    host.Changed,
+		Error:      errorString(host.Err),
+	}
+}
+
+type junitReport struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string           `xml:"name,attr"`
+	Tests     int              `xml:"tests,attr"`
+	Failures  int              `xml:"failures,attr"`
+	Errors    int              `xml:"errors,attr"`
+	Skipped   int              `xml:"skipped,attr"`
+	Time      string           `xml:"time,attr"`
+	Timestamp string           `xml:"timestamp,attr"`
+	Cases     []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message  string `xml:"message,attr"`
+	Type     string `xml:"type,attr"`
+	Contents string `xml:",chardata"`
+}
+
+func newJUnitReport(result *Result) *junitReport {
+	report := &junitReport{
+		Name: "goplay " + result.Network,
+		Time: junitTime(result.Start, result.End),
+	}
+
+	// hosts failed to connect are errors of connecting.
+	if len(result.Unreachable) > 0 {
+		suite := &junitTestSuite{
+			Name:      result.Network + ".connect",
+			Time:      junitTime(result.Start, result.Start),
+			Timestamp: junitTimestamp(result.Start),
+		}
+
+		for _, host := range result.Unreachable {
+			suite.addCase(&junitTestCase{
+				Name:      host.Host,
+				ClassName: suite.Name,
+				Time:      junitTime(host.Start, host.End),
+				Error: &junitMessage{
+					Message:  errorString(host.Err),
+					Type:     string(host.Status),
+					Contents: errorString(host.Err),
+				},
+			})
+		}
+
+		report.addSuite(suite)
+	}
+
+	// NOTE: rollbacks are reported after commands, named with commands rolled back.
+	commands := append(append([]*CommandResult{}, result.Commands...), result.Rollbacks...)
+	for _, cmd := range commands {
+		name := result.Network + "." + cmd.Name
+		switch {
+		case cmd.Rollback != "":
+			name = result.Network + ".rollback." + cmd.Rollback
+
+		case cmd.Handler:
+			name = result.Network + ".handler." + cmd.Name
+
+		case cmd.Lock:
+			name = result.Network + ".lock." + cmd.Name
+		}
+
+		suite := &junitTestSuite{
+			Name:      name,
+			Time:      junitTime(cmd.Start, cmd.End),
+			Timestamp: junitTimestamp(cmd.Start),
+		}
+
+		if cmd.Err != nil {
+			suite.addCase(&junitTestCase{
+				Name:      cmd.Name,
+				ClassName: suite.Name,
+				Time:      junitTime(cmd.Start, cmd.End),
+				Error: &junitMessage{
+					Message:  cmd.Err.Error(),
+					Type:     "error",
+					Contents: cmd.Err.Error(),
+				},
+			})
+		}
+
+		for _, book := range cmd.Books {
+			for _, host := range book.Hosts {
+				suite.addCase(newJUnitTestCase(suite.Name, book, host))
+			}
+		}
+
+		report.addSuite(suite)
+	}
+
+	return report
+}
+
+func newJUnitTestCase(className string, book *BookResult, host *HostResult) *junitTestCase {
+	testCase := &junitTestCase{
+		Name:      fmt.Sprintf("[%s] %s", host.Host, firstLine(book.Run)),
+		ClassName: className,
+		Time:      junitTime(host.Start, host.End),
+	}
+
+	switch host.Status {
+	case StatusOK:
+		// nothing to report
+
+	case StatusFailedOver:
+		testCase.Skipped = &junitMessage{
+			Message: "failed over to next host: " + errorString(host.Err),
+			Type:    string(host.Status),
+		}
+
+	case StatusSkipped:
+		testCase.Skipped = &junitMessage{
+			Message: "host has failed before",
+			Type:    string(host.Status),
+		}
+
+	default:
+		message := errorString(host.Err)
+		if host.ExitStatus > 0 {
+			message = fmt.Sprintf("exit status %d", host.ExitStatus)
+		}
+
+		testCase.Failure = &junitMessage{
+			Message:  message,
+			Type:     string(host.Status),
+			Contents: string(host.StderrTail),
+		}
+	}
+
+	if len(host.StderrTail) > 0 && testCase.Failure == nil {
+		testCase.SystemErr = string(host.StderrTail)
+	}
+
+	return testCase
+}
+
+func (report *junitReport) addSuite(suite *junitTestSuite) {
+	report.Suites = append(report.Suites, suite)
+
+	report.Tests += suite.Tests
+	report.Failures += suite.Failures
+	report.Errors += suite.Errors
+}
+
+func (suite *junitTestSuite) addCase(testCase *junitTestCase) {
+	suite.Cases = append(suite.Cases, testCase)
+
+	suite.Tests++
+	switch {
+	case testCase.Failure != nil:
+		suite.Failures++
+
+	case testCase.Error != nil:
+		suite.Errors++
+
+	case testCase.Skipped != nil:
+		suite.Skipped++
+	}
+}
+
+func junitTime(start, end time.Time) string {
+	if start.IsZero() || end.Before(start) {
+		return "0.000"
+	}
+
+	return fmt.Sprintf("%.3f", end.Sub(start).Seconds())
+}
+
+func junitTimestamp(t time.Time) string {
+	return t.Format("2006-01-02T15:04:05")
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i] + " ..."
+	}
+
+	return s
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
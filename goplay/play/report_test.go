@@ -0,0 +1,136 @@
+package play
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+)
+
+func newTestResult() *Result {
+	start := time.Date(2018, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	return &Result{
+		Network: "production",
+		Start:   start,
+		End:     start.Add(3 * time.Second),
+		Unreachable: []*HostResult{
+			{Host: "root@10.0.0.3:22", Status: StatusUnreachable, ExitStatus: -1, Err: errors.New("connecting failed")},
+		},
+		Commands: []*CommandResult{
+			{
+				Name:  "deploy",
+				Start: start,
+				End:   start.Add(2 * time.Second),
+				Books: []*BookResult{
+					{
+						Run: "make deploy",
+						Hosts: []*HostResult{
+							{Host: "root@10.0.0.1:22", Status: StatusOK, Attempts: 1, Start: start, End: start.Add(time.Second), Stdout: 10},
+							{Host: "root@10.0.0.2:22", Status: StatusFailed, ExitStatus: 2, Attempts: 1, Start: start, End: start.Add(2 * time.Second), Stderr: 5, StderrTail: []byte("oops\n"), Err: errors.New("Process exited with status 2")},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func Test_WriteReportWithJSON(t *testing.T) {
+	assertion := assert.New(t)
+
+	var buf bytes.Buffer
+
+	err := WriteReport(&buf, ReportJSON, newTestResult())
+	assertion.Nil(err)
+
+	var report struct {
+		Network     string
+		Duration    float64
+		Stats       map[string]int
+		Unreachable []map[string]interface{}
+		Commands    []struct {
+			Name  string
+			Books []struct {
+				Run   string
+				Hosts []map[string]interface{}
+			}
+		}
+	}
+
+	err = json.Unmarshal(buf.Bytes(), &report)
+	assertion.Nil(err)
+	assertion.Equal("production", report.Network)
+	assertion.Equal(3.0, report.Duration)
+	assertion.Equal(1, report.Stats["ok"])
+	assertion.Equal(1, report.Stats["failed"])
+	assertion.Equal(1, report.Stats["unreachable"])
+	assertion.Equal("unreachable", report.Unreachable[0]["status"])
+
+	host := report.Commands[0].Books[0].Hosts[1]
+	assertion.Equal("root@10.0.0.2:22", host["host"])
+	assertion.Equal("failed", host["status"])
+	assertion.Equal(2.0, host["exit_status"])
+	assertion.Equal(5.0, host["stderr_bytes"])
+	assertion.Equal("oops\n", host["stderr"])
+	assertion.Equal("Process exited with status 2", host["error"])
+}
+
+func Test_WriteReportWithJUnit(t *testing.T) {
+	assertion := assert.New(t)
+
+	var buf bytes.Buffer
+
+	err := WriteReport(&buf, ReportJUnit, newTestResult())
+	assertion.Nil(err)
+	assertion.True(bytes.HasPrefix(buf.Bytes(), []byte(xml.Header)))
+
+	var report junitReport
+
+	err = xml.Unmarshal(buf.Bytes(), &report)
+	assertion.Nil(err)
+	assertion.Equal(3, report.Tests)
+	assertion.Equal(1, report.Failures)
+	assertion.Equal(1, report.Errors)
+	assertion.Equal("3.000", report.Time)
+
+	if assertion.Len(report.Suites, 2) {
+		connect := report.Suites[0]
+		assertion.Equal("production.connect", connect.Name)
+		assertion.Equal("connecting failed", connect.Cases[0].Error.Message)
+
+		deploy := report.Suites[1]
+		assertion.Equal("production.deploy", deploy.Name)
+		assertion.Equal(2, deploy.Tests)
+		assertion.Equal("2018-05-01T10:00:00", deploy.Timestamp)
+		assertion.Equal("[root@10.0.0.1:22] make deploy", deploy.Cases[0].Name)
+		assertion.Nil(deploy.Cases[0].Failure)
+		assertion.Equal("exit status 2", deploy.Cases[1].Failure.Message)
+		assertion.Equal("oops\n", deploy.Cases[1].Failure.Contents)
+	}
+}
+
+func Test_WriteReportWithUnsupported(t *testing.T) {
+	assertion := assert.New(t)
+
+	assertion.False(IsValidReportFormat("yaml"))
+	assertion.Equal(ErrNotSupported, WriteReport(&bytes.Buffer{}, "yaml", newTestResult()))
+}
+
+func Test_TailWriter(t *testing.T) {
+	assertion := assert.New(t)
+
+	var data []byte
+
+	w := tailWriter{&data, 4}
+	w.Write([]byte("ab"))
+	w.Write([]byte("cde"))
+	assertion.Equal("bcde", string(data))
+
+	w.Write([]byte("123456"))
+	assertion.Equal("3456", string(data))
+}
@@ -0,0 +1,220 @@
+package play
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxStderrTail is the max bytes of STDERR kept by HostResult.
+const maxStderrTail = 64 << 10
+
+// Status represents status of running book on host.
+type Status string
+
+// Supported statuses of HostResult.
+const (
+	StatusOK          Status = "ok"
+	StatusFailed      Status = "failed"
+	StatusFailedOver  Status = "failed_over" // Failed, but once command succeeded on other host.
+	StatusTimedOut    Status = "timed_out"
+	StatusCancelled   Status = "cancelled"
+	StatusUnreachable Status = "unreachable"
+	StatusSkipped     Status = "skipped" // Not run, the host has dropped out for failures, see FailurePolicy.
+)
+
+// Result represents result of running commands on network.
+type Result struct {
+	Network     string
+	Start       time.Time
+	End         time.Time
+	Unreachable []*HostResult // Hosts failed to connect.
+	Commands    []*CommandResult
+	Aborted     string           // Reason of aborting the run, see FailurePolicy.
+	Canary      []string         // Hosts of canary of the run, see Play.Canary.
+	Promoted    bool             // Canary of the run is promoted to the rest of hosts.
+	Rollbacks   []*CommandResult // Rollbacks of commands failed, see Command.Rollback.
+}
+
+// CommandResult represents result of running command.
+type CommandResult struct {
+	Name     string
+	Start    time.Time
+	End      time.Time
+	OnceHost string // Host ran the once command, it's empty if failed on all hosts.
+	Books    []*BookResult
+	Err      error    // Error of planning, e.g. resolving env vars failed.
+	Handler  bool     // Command is handler notified, see Playfile.Handlers.
+	Lock     bool     // Command is lock of the run named with lock, see Play.Lock.
+	Rollback string   // Name of command rolled back, it's set for rollbacks only, see Command.Rollback.
+	Canary   []string // Hosts of canary of the command, see Command.Canary.
+	Promoted bool     // Canary of the command is promoted to the rest of hosts.
+}
+
+// BookResult represents result of running book on its hosts.
+type BookResult struct {
+	Run   string // Command of book without env vars.
+	Hosts []*HostResult
+}
+
+// HostResult represents result of running book on host.
+type HostResult struct {
+	Host       string // Host in form of user@host:port, without passwd.
+	Status     Status
+	ExitStatus int // Exit status of book, it's -1 if the book did not exit normally.
+	Attempts   int // Number of runs, including retries.
+	Start      time.Time
+	End        time.Time
+	Stdout     int64  // Bytes of STDOUT of the last run.
+	Stderr     int64  // Bytes of STDERR of the last run.
+	StderrTail []byte // Tail of STDERR of the last run with secrets masked, it's at most 64KB.
+	Changed    bool   // Host is changed by book, e.g. files differ from upload, see Play.Check.
+	Err        error
+
+	client Client
+}
+
+// Stats represents numbers of host results by status.
+type Stats struct {
+	OK          int
+	Failed      int
+	FailedOver  int
+	TimedOut    int
+	Cancelled   int
+	Unreachable int
+	Skipped     int // Not counted as failures, hosts have failed before.
+	Errors      int // Commands failed to plan.
+}
+
+// Failures returns total number of failures, hosts failed over or skipped are
+// not counted.
+func (s Stats) Failures() int {
+	return s.Failed + s.TimedOut + s.Cancelled + s.Unreachable + s.Errors
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("ok=%d failed=%d failed_over=%d timed_out=%d cancelled=%d unreachable=%d skipped=%d errors=%d",
+		s.OK, s.Failed, s.FailedOver, s.TimedOut, s.Cancelled, s.Unreachable, s.Skipped, s.Errors)
+}
+
+// Stats returns stats of all host results, rollbacks are excluded.
+func (r *Result) Stats() (stats Stats) {
+	stats = commandStats(r.Commands)
+	stats.Unreachable = len(r.Unreachable)
+
+	return
+}
+
+// RollbackStats returns stats of host results of rollbacks.
+func (r *Result) RollbackStats() Stats {
+	return commandStats(r.Rollbacks)
+}
+
+// commandStats returns stats of host results of commands.
+func commandStats(commands []*CommandResult) (stats Stats) {
+	for _, cmd := range commands {
+		if cmd.Err != nil {
+			stats.Errors++
+		}
+
+		for _, book := range cmd.Books {
+			for _, host := range book.Hosts {
+				switch host.Status {
+				case StatusOK:
+					stats.OK++
+
+				case StatusFailedOver:
+					stats.FailedOver++
+
+				case StatusTimedOut:
+					stats.TimedOut++
+
+				case StatusCancelled:
+					stats.Cancelled++
+
+				case StatusSkipped:
+					stats.Skipped++
+
+				default:
+					stats.Failed++
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// Failed returns true if any of hosts failed, see Stats.Failures.
+func (r *Result) Failed() bool {
+	return r.Stats().Failures() > 0
+}
+
+// countReader counts bytes read from its reader.
+type countReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+func (r countReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+
+	*r.count += int64(n)
+
+	return
+}
+
+// tailWriter keeps the last max bytes written to it.
+type tailWriter struct {
+	data *[]byte
+	max  int
+}
+
+func (w tailWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+
+	if len(p) >= w.max {
+		*w.data = append((*w.data)[:0], p[len(p)-w.max:]...)
+
+		return
+	}
+
+	data := append(*w.data, p...)
+	if len(data) > w.max {
+		data = data[len(data)-w.max:]
+	}
+	*w.data = data
+
+	return
+}
+
+// markerWriter marks changed once marker is written, marker split between
+// writes is matched too.
+type markerWriter struct {
+	marker  []byte
+	tail    []byte // Tail of data written, it's shorter than marker.
+	changed *bool
+}
+
+func (w *markerWriter) Write(p []byte) (n int, err error) {
+	n = len(p)
+
+	if *w.changed {
+		return
+	}
+
+	data := append(w.tail, p...)
+	if bytes.Contains(data, w.marker) {
+		*w.changed = true
+
+		return
+	}
+
+	if keep := len(w.marker) - 1; len(data) > keep {
+		data = data[len(data)-keep:]
+	}
+	w.tail = append(w.tail[:0], data...)
+
+	return
+}
@@ -0,0 +1,68 @@
+package play
+
+import (
+	"math/rand"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = 5 * time.Minute
+)
+
+var (
+	random    = rand.New(rand.NewSource(time.Now().UnixNano()))
+	randomMux sync.Mutex
+)
+
+// retryBackoff returns delay before the attempt-th re-run. The delay is
+// doubled for each attempt up to maxRetryDelay, with jitter of ±50% for
+// spreading re-runs of hosts.
+func retryBackoff(delay time.Duration, attempt int) time.Duration {
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+
+	backoff := delay
+	for i := 1; i < attempt && backoff < maxRetryDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryDelay {
+		backoff = maxRetryDelay
+	}
+
+	randomMux.Lock()
+	jitter := time.Duration(random.Int63n(int64(backoff)))
+	randomMux.Unlock()
+
+	return backoff/2 + jitter
+}
+
+// exitStatus returns exit status of error returned by Client.Wait(), it
+// returns 0 for nil and -1 if the book did not exit normally, e.g. killed
+// by signal or connection lost.
+func exitStatus(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+
+	case *ssh.ExitError:
+		if e.Signal() != "" {
+			return -1
+		}
+
+		return e.ExitStatus()
+
+	case *exec.ExitError:
+		if status, ok := e.Sys().(syscall.WaitStatus); ok && status.Exited() {
+			return status.ExitStatus()
+		}
+	}
+
+	return -1
+}
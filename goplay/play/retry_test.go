@@ -0,0 +1,39 @@
+package play
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/golib/assert"
+)
+
+func Test_RetryBackoff(t *testing.T) {
+	assertion := assert.New(t)
+
+	for attempt, backoff := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		20: maxRetryDelay,
+	} {
+		delay := retryBackoff(time.Second, attempt)
+		assertion.True(delay >= backoff/2, attempt)
+		assertion.True(delay < backoff*3/2, attempt)
+	}
+
+	// default delay
+	delay := retryBackoff(0, 1)
+	assertion.True(delay >= defaultRetryDelay/2)
+	assertion.True(delay < defaultRetryDelay*3/2)
+}
+
+func Test_ExitStatus(t *testing.T) {
+	assertion := assert.New(t)
+
+	assertion.Equal(0, exitStatus(nil))
+	assertion.Equal(-1, exitStatus(errors.New("connection lost")))
+	assertion.Equal(3, exitStatus(exec.Command("bash", "-c", "exit 3").Run()))
+	assertion.Equal(-1, exitStatus(exec.Command("bash", "-c", "kill -9 $$").Run()))
+}
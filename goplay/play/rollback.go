@@ -0,0 +1,107 @@
+package play
+
+import (
+	"context"
+)
+
+// rollback runs rollback of cmd on hosts failed by it, and hosts succeeded
+// too for RollbackAll, see Command.Rollback. Failures of rollback are tracked
+// separately, they neither drop hosts out of the run nor abort it. Result of
+// the rollback is added to result.Rollbacks. Nothing is rolled back for check.
+func (play *Play) rollback(ctx context.Context, network *Network, cmd *Command, cmdResult *CommandResult, envs, networkEnvs EnvVars, result *Result) {
+	if cmd.Rollback == "" || play.check || ctx.Err() != nil {
+		return
+	}
+
+	failed, succeeded := commandHosts(cmdResult)
+	if len(failed) == 0 {
+		return
+	}
+
+	clients := failed
+	if cmd.RollbackScope == RollbackAll {
+		clients = append(clients, succeeded...)
+	}
+
+	rollback := play.rollbackCommand(cmd)
+
+	Warnf("Command %s failed on %d host(s), rolling back %d host(s) with %s\n", cmd.Name, len(failed), len(clients), rollback.Name)
+
+	// NOTE: rollbacks are not tracked by state of the run, see Play.State.
+	failures, step := play.failures, play.step
+	play.failures = newFailureTracker(len(clients), 0)
+	play.step = ""
+
+	rollbackResult := play.runCommand(ctx, network, clients, rollback, envs, networkEnvs)
+	rollbackResult.Rollback = cmd.Name
+
+	play.failures, play.step = failures, step
+
+	result.Rollbacks = append(result.Rollbacks, rollbackResult)
+}
+
+// rollbackCommand returns command rolling back cmd, it's the command named
+// with Command.Rollback if exists, or a command running it otherwise. The
+// latter shares env vars and template of cmd.
+func (play *Play) rollbackCommand(cmd *Command) *Command {
+	if play.config != nil {
+		if rollback, ok := play.config.Commands.Get(cmd.Rollback); ok {
+			rollback.Name = cmd.Rollback
+
+			return &rollback
+		}
+	}
+
+	return &Command{
+		Name:     cmd.Name + ".rollback",
+		Run:      cmd.Rollback,
+		EnvFile:  cmd.EnvFile,
+		Env:      cmd.Env,
+		Locally:  cmd.Locally,
+		Template: cmd.Template,
+	}
+}
+
+// commandHosts returns clients failed by command of result, and clients
+// succeeded on all its books, in order of results. Hosts failed over by once
+// command, or skipped for failures of previous commands, are neither of them.
+func commandHosts(result *CommandResult) (failed, succeeded []Client) {
+	var (
+		clients  []Client
+		statuses = make(map[Client]Status)
+	)
+	for _, book := range result.Books {
+		for _, host := range book.Hosts {
+			if host.client == nil {
+				continue
+			}
+
+			status, ok := statuses[host.client]
+			if !ok {
+				clients = append(clients, host.client)
+			}
+
+			switch {
+			case !ok || status == StatusOK:
+				statuses[host.client] = host.Status
+
+			case host.Status != StatusOK && host.Status != StatusFailedOver && host.Status != StatusSkipped:
+				statuses[host.client] = host.Status
+			}
+		}
+	}
+
+	for _, client := range clients {
+		switch statuses[client] {
+		case StatusOK:
+			succeeded = append(succeeded, client)
+
+		case StatusFailedOver, StatusSkipped:
+
+		default:
+			failed = append(failed, client)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,191 @@
+package play
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Serial defines sizes of batches running command in turn, e.g. 2, "25%" and
+// [1, 5, "20%"]. The last size is repeated for the rest of hosts. It's empty
+// for running command on all hosts at once.
+type Serial []BatchSize
+
+// BatchSize defines number of hosts of a batch, it's percentage of all hosts
+// if Percent is true.
+type BatchSize struct {
+	Size    int
+	Percent bool
+}
+
+// ParseSerial parses sizes of batches separated by comma, e.g. "1,5,20%".
+func ParseSerial(s string) (Serial, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return nil, nil
+	}
+
+	var serial Serial
+	for _, item := range strings.Split(s, ",") {
+		size, err := parseBatchSize(item)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid serial")
+		}
+
+		serial = append(serial, size)
+	}
+
+	return serial, nil
+}
+
+func parseBatchSize(s string) (BatchSize, error) {
+	s = strings.TrimSpace(s)
+
+	size := BatchSize{
+		Percent: strings.HasSuffix(s, "%"),
+	}
+
+	n, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || n <= 0 || (size.Percent && n > 100) {
+		return size, errors.Errorf("invalid size %q, it must be a positive number or percentage", s)
+	}
+	size.Size = n
+
+	return size, nil
+}
+
+// Batches returns number of hosts of each batch for total hosts given. Sizes
+// of percentage are rounded down, and at least 1.
+func (s Serial) Batches(total int) (sizes []int) {
+	if total <= 0 {
+		return
+	}
+
+	if len(s) == 0 {
+		return []int{total}
+	}
+
+	for i, remaining := 0, total; remaining > 0; i++ {
+		size := s[len(s)-1].of(total)
+		if i < len(s) {
+			size = s[i].of(total)
+		}
+
+		if size > remaining {
+			size = remaining
+		}
+
+		sizes = append(sizes, size)
+		remaining -= size
+	}
+
+	return
+}
+
+func (s Serial) String() string {
+	items := make([]string, len(s))
+	for i, size := range s {
+		items[i] = size.String()
+	}
+
+	return strings.Join(items, ",")
+}
+
+func (s *Serial) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	var items []interface{}
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+		return nil
+
+	case int:
+		if v == 0 {
+			*s = nil
+			return nil
+		}
+
+		items = []interface{}{v}
+
+	case string:
+		serial, err := ParseSerial(v)
+		if err != nil {
+			return err
+		}
+
+		*s = serial
+		return nil
+
+	case []interface{}:
+		items = v
+
+	default:
+		return errors.Errorf("invalid serial %v, it must be a number, percentage or list of them", v)
+	}
+
+	serial := make(Serial, 0, len(items))
+	for _, item := range items {
+		size, err := parseBatchSize(fmt.Sprint(item))
+		if err != nil {
+			return errors.Wrap(err, "invalid serial")
+		}
+
+		serial = append(serial, size)
+	}
+	*s = serial
+
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, Serial of one number is marshaled as
+// number for compatibility.
+func (s Serial) MarshalYAML() (interface{}, error) {
+	items := make([]interface{}, len(s))
+	for i, size := range s {
+		if size.Percent {
+			items[i] = size.String()
+		} else {
+			items[i] = size.Size
+		}
+	}
+
+	switch len(items) {
+	case 0:
+		return nil, nil
+
+	case 1:
+		return items[0], nil
+	}
+
+	return items, nil
+}
+
+func (b BatchSize) String() string {
+	if b.Percent {
+		return fmt.Sprintf("%d%%", b.Size)
+	}
+
+	return strconv.Itoa(b.Size)
+}
+
+// of returns number of hosts of the batch for total hosts given.
+func (b BatchSize) of(total int) int {
+	if !b.Percent {
+		return b.Size
+	}
+
+	n := total * b.Size / 100
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
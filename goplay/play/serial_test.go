@@ -0,0 +1,89 @@
+package play
+
+import (
+	"testing"
+
+	"github.com/golib/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_ParseSerial(t *testing.T) {
+	assertion := assert.New(t)
+
+	for s, expected := range map[string]Serial{
+		"":          nil,
+		"0":         nil,
+		"2":         {{Size: 2}},
+		"25%":       {{Size: 25, Percent: true}},
+		"1, 5,20%":  {{Size: 1}, {Size: 5}, {Size: 20, Percent: true}},
+		" 100% , 3": {{Size: 100, Percent: true}, {Size: 3}},
+	} {
+		serial, err := ParseSerial(s)
+		assertion.Nil(err, s)
+		assertion.Equal(expected, serial, s)
+	}
+
+	for _, s := range []string{"-1", "0%", "101%", "x", "1,,2"} {
+		_, err := ParseSerial(s)
+		assertion.NotNil(err, s)
+	}
+}
+
+func Test_SerialBatches(t *testing.T) {
+	assertion := assert.New(t)
+
+	for s, expected := range map[string][]int{
+		"":        {10},
+		"3":       {3, 3, 3, 1},
+		"25%":     {2, 2, 2, 2, 2},
+		"5%":      {1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+		"1,5,20%": {1, 5, 2, 2},
+		"20,1":    {10},
+	} {
+		serial, err := ParseSerial(s)
+		assertion.Nil(err)
+		assertion.Equal(expected, serial.Batches(10), s)
+	}
+
+	assertion.Empty(Serial{{Size: 2}}.Batches(0))
+}
+
+func Test_SerialYAML(t *testing.T) {
+	assertion := assert.New(t)
+
+	var cmd Command
+
+	err := yaml.Unmarshal([]byte("serial: [1, 5, 20%]"), &cmd)
+	assertion.Nil(err)
+	assertion.Equal(Serial{{Size: 1}, {Size: 5}, {Size: 20, Percent: true}}, cmd.Serial)
+	assertion.Equal("1,5,20%", cmd.Serial.String())
+
+	data, err := yaml.Marshal(cmd)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "serial:\n- 1\n- 5\n- 20%\n")
+
+	err = yaml.Unmarshal([]byte("serial: 25%"), &cmd)
+	assertion.Nil(err)
+	assertion.Equal(Serial{{Size: 25, Percent: true}}, cmd.Serial)
+
+	err = yaml.Unmarshal([]byte("serial: 2"), &cmd)
+	assertion.Nil(err)
+
+	data, err = yaml.Marshal(cmd)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "serial: 2\n")
+
+	err = yaml.Unmarshal([]byte("serial: 0"), &cmd)
+	assertion.Nil(err)
+	assertion.Empty(cmd.Serial)
+
+	data, err = yaml.Marshal(cmd)
+	assertion.Nil(err)
+	assertion.NotContains(string(data), "serial")
+
+	err = yaml.Unmarshal([]byte("serial: [1, 0]"), &cmd)
+	assertion.NotNil(err)
+
+	err = yaml.Unmarshal([]byte("serial: {size: 1}"), &cmd)
+	assertion.NotNil(err)
+}
@@ -168,7 +168,7 @@ func (c *SSHClient) Run(book *Book) error {
 	}
 
 	// Start the remote command.
-	c.lastError = sess.Start(c.env + book.run)
+	c.lastError = sess.Start(c.env + book.command(c))
 	if c.lastError != nil {
 		c.lastError = ErrBook{book, c.lastError.Error()}
 
@@ -228,6 +228,17 @@ func (c *SSHClient) Close() error {
 	return c.lastError
 }
 
+// closeSession closes the running session, but keeps the SSH connection.
+func (c *SSHClient) closeSession() error {
+	if !c.isOpened {
+		return ErrNotOpened
+	}
+
+	c.stdin.Close()
+
+	return c.sess.Close()
+}
+
 func (c *SSHClient) Stdin() io.WriteCloser {
 	return c.stdin
 }
@@ -240,6 +251,9 @@ func (c *SSHClient) Stderr() io.Reader {
 	return c.stderr
 }
 
+// Signal sends sig to the running session, only os.Interrupt and os.Kill are
+// supported.
+// NOTE: It's called while waiting, so lastError is not touched.
 func (c *SSHClient) Signal(sig os.Signal) error {
 	if !c.isOpened {
 		return ErrNotOpened
@@ -254,9 +268,10 @@ func (c *SSHClient) Signal(sig os.Signal) error {
 		// https://github.com/golang/go/issues/4115#issuecomment-66070418
 		c.stdin.Write([]byte("\x03"))
 
-		c.lastError = c.sess.Signal(ssh.SIGINT)
+		return c.sess.Signal(ssh.SIGINT)
 
-		return c.lastError
+	case os.Kill:
+		return c.sess.Signal(ssh.SIGKILL)
 
 	default:
 		return fmt.Errorf("Signal %v is not supported", sig)
@@ -0,0 +1,277 @@
+package play
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunState represents progress of a run persisted to file for resuming, see
+// Play.State. It records hosts completed each step of the run, a step is a
+// book of command, e.g. an upload or the run of command.
+type RunState struct {
+	ID       string              `json:"id"`
+	Playfile string              `json:"playfile,omitempty"`
+	Profile  string              `json:"profile,omitempty"`
+	Network  string              `json:"network"`
+	Commands []string            `json:"commands"` // Names of commands and books run, as given.
+	Start    time.Time           `json:"start"`
+	Update   time.Time           `json:"update"`
+	Done     bool                `json:"done"`               // All steps have completed on all hosts.
+	Steps    map[string][]string `json:"steps"`              // Hosts completed each step.
+	Notified map[string][]string `json:"notified,omitempty"` // Hosts notified of each handler, which has not run on them yet.
+
+	filename string
+}
+
+// NewRunState returns state of a new run saved in dir, it's named with an ID
+// generated from time and random bytes. Nothing is written before Save.
+func NewRunState(dir, network string, commands []string) *RunState {
+	now := time.Now()
+
+	random := make([]byte, 3)
+	rand.Read(random)
+
+	id := now.Format("20060102-150405") + "-" + hex.EncodeToString(random)
+
+	return &RunState{
+		ID:       id,
+		Network:  network,
+		Commands: commands,
+		Start:    now,
+		Update:   now,
+		Steps:    make(map[string][]string),
+		filename: filepath.Join(dir, id+".json"),
+	}
+}
+
+// LoadRunState returns state of run with id given saved in dir.
+func LoadRunState(dir, id string) (*RunState, error) {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.HasPrefix(id, ".") {
+		return nil, errors.Errorf("invalid run id %q", id)
+	}
+
+	filename := filepath.Join(dir, id+".json")
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("run %s does not exist", id)
+		}
+
+		return nil, err
+	}
+
+	var state RunState
+
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing state of run %s failed", id)
+	}
+
+	if state.Steps == nil {
+		state.Steps = make(map[string][]string)
+	}
+	state.filename = filename
+
+	return &state, nil
+}
+
+// Filename returns path of file the state is saved to.
+func (s *RunState) Filename() string {
+	return s.filename
+}
+
+// Save writes state to its file atomically, dir of the file is created if it
+// does not exist. The file is readable by the owner only.
+func (s *RunState) Save() error {
+	s.Update = time.Now()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.filename), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmpfile := s.filename + ".tmp"
+
+	err = ioutil.WriteFile(tmpfile, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpfile, s.filename)
+}
+
+// Completed returns true if step has completed on host given.
+func (s *RunState) Completed(step, host string) bool {
+	for _, name := range s.Steps[step] {
+		if name == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// complete marks step as completed on hosts given, and saves state.
+func (s *RunState) complete(step string, hosts []string) error {
+	for _, host := range hosts {
+		if !s.Completed(step, host) {
+			s.Steps[step] = append(s.Steps[step], host)
+		}
+	}
+
+	return s.Save()
+}
+
+// bookStep returns step of book run by the current command of play, see
+// Play.step. Books are identified by their content, for books of upload are
+// created in random order. Runs are identified as given, so that a run resumed
+// with or without --debug matches steps completed.
+func (play *Play) bookStep(book *Book) string {
+	var content string
+	switch {
+	case book.upload != nil:
+		content = fmt.Sprintf("upload %s -> %s %s", book.upload.Src, book.upload.Dst, book.upload.Filter)
+
+	case book.healthCheck:
+		content = "health_check " + book.source
+
+	default:
+		content = "run " + book.source
+	}
+
+	sum := sha256.Sum256([]byte(content))
+
+	return play.step + "#" + hex.EncodeToString(sum[:6])
+}
+
+// skipCompleted returns clients which have not completed book before the run
+// is resumed, see Play.State.
+func (play *Play) skipCompleted(cmd *Command, book *Book, clients []Client) []Client {
+	if play.state == nil || book.step == "" {
+		return clients
+	}
+
+	var pending []Client
+	for _, client := range clients {
+		if !play.state.Completed(book.step, play.hostName(client)) {
+			pending = append(pending, client)
+		}
+	}
+
+	if completed := len(clients) - len(pending); completed > 0 {
+		Infof("Command %s skipping %d host(s) completed before resuming\n", cmd.Name, completed)
+	}
+
+	return pending
+}
+
+// completeBook marks book as completed on hosts succeeded, and saves state of
+// the run, see Play.State.
+func (play *Play) completeBook(book *Book, hosts []*HostResult) {
+	if play.state == nil || book.step == "" {
+		return
+	}
+
+	var names []string
+	for _, host := range hosts {
+		if host.Status == StatusOK {
+			names = append(names, host.Host)
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	err := play.state.complete(book.step, names)
+	if err != nil {
+		Warnf("%v\n", errors.Wrap(err, "saving state of run failed"))
+	}
+}
+
+// completedOnce returns the candidate which has completed all books of once
+// command before the run is resumed, it's nil if none of them completed.
+func (play *Play) completedOnce(books []*Book, candidates []Client) Client {
+	if play.state == nil || len(books) == 0 || books[0].step == "" {
+		return nil
+	}
+
+	for _, client := range candidates {
+		name := play.hostName(client)
+
+		completed := true
+		for _, book := range books {
+			if !play.state.Completed(book.step, name) {
+				completed = false
+				break
+			}
+		}
+		if completed {
+			return client
+		}
+	}
+
+	return nil
+}
+
+// saveNotified saves hosts notified of handlers to state of the run, they are
+// notified again once the run is resumed.
+func (play *Play) saveNotified() {
+	if play.state == nil {
+		return
+	}
+
+	notified := make(map[string][]string)
+	for name, clients := range play.notified {
+		for client, ok := range clients {
+			if ok {
+				notified[name] = append(notified[name], play.hostName(client))
+			}
+		}
+	}
+	play.state.Notified = notified
+
+	err := play.state.Save()
+	if err != nil {
+		Warnf("%v\n", errors.Wrap(err, "saving state of run failed"))
+	}
+}
+
+// restoreNotified notifies handlers on clients notified before the run is
+// resumed, see RunState.Notified.
+func (play *Play) restoreNotified(clients []Client) {
+	if play.state == nil {
+		return
+	}
+
+	for name, hosts := range play.state.Notified {
+		for _, client := range clients {
+			for _, host := range hosts {
+				if play.hostName(client) != host {
+					continue
+				}
+
+				if play.notified[name] == nil {
+					play.notified[name] = make(map[Client]bool)
+				}
+
+				play.notified[name][client] = true
+			}
+		}
+	}
+}
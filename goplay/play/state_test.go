@@ -0,0 +1,75 @@
+package play
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_RunState(t *testing.T) {
+	assertion := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	state := NewRunState(filepath.Join(dir, "runs"), "production", []string{"deploy"})
+	assertion.NotEmpty(state.ID)
+	assertion.Equal(filepath.Join(dir, "runs", state.ID+".json"), state.Filename())
+
+	_, err = LoadRunState(filepath.Join(dir, "runs"), state.ID)
+	assertion.EqualError(err, "run "+state.ID+" does not exist")
+
+	assertion.Nil(state.complete("1.deploy#abc", []string{"root@app1:22", "root@app2:22"}))
+	assertion.Nil(state.complete("1.deploy#abc", []string{"root@app1:22"}))
+
+	info, err := os.Stat(state.Filename())
+	assertion.Nil(err)
+	assertion.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := LoadRunState(filepath.Join(dir, "runs"), state.ID)
+	assertion.Nil(err)
+	assertion.Equal("production", loaded.Network)
+	assertion.Equal([]string{"deploy"}, loaded.Commands)
+	assertion.Equal([]string{"root@app1:22", "root@app2:22"}, loaded.Steps["1.deploy#abc"])
+	assertion.True(loaded.Completed("1.deploy#abc", "root@app2:22"))
+	assertion.False(loaded.Completed("1.deploy#abc", "root@app3:22"))
+	assertion.False(loaded.Completed("2.restart#abc", "root@app1:22"))
+	assertion.False(loaded.Done)
+
+	_, err = LoadRunState(dir, "../runs/"+state.ID)
+	assertion.NotNil(err)
+}
+
+func Test_PlayBookStepWithDebug(t *testing.T) {
+	assertion := assert.New(t)
+
+	player, err := New(&Playfile{})
+	assertion.Nil(err)
+	player.step = "1.deploy"
+
+	client := NewLocalClient("")
+	client.Connect("localhost")
+
+	cmd := &Command{Run: "echo deploy", HealthCheck: "true"}
+
+	books, err := player.createBooks([]Client{client}, cmd, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal(2, len(books))
+
+	player.Debug(true)
+
+	debugBooks, err := player.createBooks([]Client{client}, cmd, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal(2, len(debugBooks))
+	assertion.Equal("set -x;echo deploy", debugBooks[0].run)
+
+	// steps of run resumed with --debug are the same
+	for i, book := range books {
+		assertion.Equal(player.bookStep(book), player.bookStep(debugBooks[i]))
+	}
+	assertion.NotEqual(player.bookStep(books[0]), player.bookStep(books[1]))
+}
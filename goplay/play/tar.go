@@ -19,6 +19,13 @@ func RemoteTarCommand(dir string) string {
 	return fmt.Sprintf("tar -C \"%s\" -xzf -", dir)
 }
 
+// RemoteTarCheckCommand returns command to be run on remote SSH host to
+// send TAR stream of files existing in dir, names of files are read from
+// STDIN line by line. It sends nothing if dir does not exist.
+func RemoteTarCheckCommand(dir string) string {
+	return fmt.Sprintf("cd \"%s\" 2>/dev/null && tar -cf - -T - 2>/dev/null || cat >/dev/null", dir)
+}
+
 // LocalTarCmdArgs returns command to be run on local host.
 func LocalTarCmdArgs(path, exclude string) []string {
 	args := []string{}
@@ -0,0 +1,219 @@
+package play
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os/user"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// factsCommand prints facts of remote host in form of key=value per line.
+	factsCommand = `echo "hostname=$(hostname)"; echo "os=$(uname -s)"; echo "arch=$(uname -m)"; echo "kernel=$(uname -r)"`
+)
+
+// HostContext represents data for rendering templated Command.Run and script contents.
+type HostContext struct {
+	Host    string            // Host address in form of host:port, without user and passwd.
+	User    string            // User of host.
+	Index   int               // Index of host in network, starts from 0.
+	Network string            // Network name.
+	Env     map[string]string // Env vars exported to host.
+	Facts   map[string]string // Facts gathered from host, e.g. hostname, os, arch and kernel.
+}
+
+// NewHostContext returns HostContext of the host at index of network.
+// It expects the host of the form "[ssh://][user:passwd@]host[:port]".
+func NewHostContext(network *Network, index int, host string) (*HostContext, error) {
+	ctx := &HostContext{
+		Index:   index,
+		Network: network.Name,
+		Env: map[string]string{
+			"PLAY_HOST": MaskUserHostWithPasswd(host),
+		},
+	}
+
+	switch host {
+	case "localhost", "127.0.0.1":
+		cu, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.Host = host
+		ctx.User = cu.Username
+
+	default:
+		remote := &SSHClient{
+			user: network.User,
+		}
+
+		err := remote.parseHost(host)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.Host = remote.host
+		ctx.User = remote.user
+	}
+
+	return ctx, nil
+}
+
+// Render renders text as Go template with the host context and env vars given.
+// It returns text as is if it is not a template.
+func (ctx HostContext) Render(text string, envs EnvVars) (string, error) {
+	if !IsTemplate(text) {
+		return text, nil
+	}
+
+	tpl, err := template.New(ctx.Host).Parse(text)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing template failed")
+	}
+
+	// host specific env vars, e.g. PLAY_HOST, take precedence
+	env := envs.Map()
+	for key, value := range ctx.Env {
+		env[key] = value
+	}
+	ctx.Env = env
+
+	var buf bytes.Buffer
+
+	err = tpl.Execute(&buf, ctx)
+	if err != nil {
+		return "", errors.Wrap(err, "rendering template failed")
+	}
+
+	return buf.String(), nil
+}
+
+// IsTemplate returns true if text contains Go template actions.
+func IsTemplate(text string) bool {
+	return strings.Contains(text, "{{")
+}
+
+// referencesFacts returns true if templated text references Facts of host
+// context, e.g. {{.Facts.os}}, {{with .Facts}} and {{$.Facts}}. Facts are
+// gathered from hosts only for templates referencing them.
+func referencesFacts(text string) bool {
+	tpl, err := template.New("facts").Parse(text)
+	if err != nil {
+		// NOTE: errors of template are reported by rendering.
+		return false
+	}
+
+	for _, t := range tpl.Templates() {
+		if t.Tree != nil && nodeReferencesFacts(t.Tree.Root) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// nodeReferencesFacts returns true if node or any of its children references
+// Facts field.
+func nodeReferencesFacts(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+
+		for _, child := range n.Nodes {
+			if nodeReferencesFacts(child) {
+				return true
+			}
+		}
+
+	case *parse.ActionNode:
+		return nodeReferencesFacts(n.Pipe)
+
+	case *parse.TemplateNode:
+		return nodeReferencesFacts(n.Pipe)
+
+	case *parse.IfNode:
+		return nodeReferencesFacts(&n.BranchNode)
+
+	case *parse.RangeNode:
+		return nodeReferencesFacts(&n.BranchNode)
+
+	case *parse.WithNode:
+		return nodeReferencesFacts(&n.BranchNode)
+
+	case *parse.BranchNode:
+		return nodeReferencesFacts(n.Pipe) || nodeReferencesFacts(n.List) || nodeReferencesFacts(n.ElseList)
+
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+
+		for _, cmd := range n.Cmds {
+			if nodeReferencesFacts(cmd) {
+				return true
+			}
+		}
+
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if nodeReferencesFacts(arg) {
+				return true
+			}
+		}
+
+	case *parse.FieldNode:
+		return len(n.Ident) > 0 && n.Ident[0] == "Facts"
+
+	case *parse.VariableNode:
+		return len(n.Ident) > 1 && n.Ident[0] == "$" && n.Ident[1] == "Facts"
+
+	case *parse.ChainNode:
+		// NOTE: field of any chain, e.g. {{(.).Facts}}, is taken as of dot.
+		return (len(n.Field) > 0 && n.Field[0] == "Facts") || nodeReferencesFacts(n.Node)
+	}
+
+	return false
+}
+
+// gatherHostFacts runs factsCommand on client and returns parsed facts.
+func gatherHostFacts(client Client) (facts map[string]string, err error) {
+	err = client.Run(&Book{
+		run: factsCommand,
+	})
+	if err != nil {
+		return
+	}
+
+	output, err := ioutil.ReadAll(client.Stdout())
+	if err != nil {
+		client.Wait()
+		return
+	}
+
+	err = client.Wait()
+	if err != nil {
+		return
+	}
+
+	facts = make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		kv := strings.SplitN(strings.TrimSpace(scanner.Text()), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		facts[kv[0]] = kv[1]
+	}
+
+	return
+}
@@ -0,0 +1,85 @@
+package play
+
+import (
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_HostContext(t *testing.T) {
+	assertion := assert.New(t)
+
+	network := &Network{
+		Name: "app",
+		User: "deploy",
+	}
+
+	hostCtx, err := NewHostContext(network, 2, "root:passwd@10.0.0.3")
+	assertion.Nil(err)
+	assertion.Equal("10.0.0.3:22", hostCtx.Host)
+	assertion.Equal("root", hostCtx.User)
+	assertion.Equal(2, hostCtx.Index)
+	assertion.Equal("app", hostCtx.Network)
+
+	hostCtx, err = NewHostContext(network, 0, "10.0.0.1:2222")
+	assertion.Nil(err)
+	assertion.Equal("10.0.0.1:2222", hostCtx.Host)
+	assertion.Equal("deploy", hostCtx.User)
+}
+
+func Test_HostContextRender(t *testing.T) {
+	assertion := assert.New(t)
+
+	network := &Network{
+		Name: "app",
+	}
+	envs := EnvVars{
+		{Key: "SERVICE", Value: "api"},
+	}
+
+	hostCtx, err := NewHostContext(network, 1, "deploy@10.0.0.2")
+	assertion.Nil(err)
+
+	// not a template
+	text, err := hostCtx.Render("echo $SERVICE", envs)
+	assertion.Nil(err)
+	assertion.Equal("echo $SERVICE", text)
+
+	text, err = hostCtx.Render("start {{.Env.SERVICE}} --node-id={{.Index}} --network={{.Network}} --host={{.Env.PLAY_HOST}}", envs)
+	assertion.Nil(err)
+	assertion.Equal("start api --node-id=1 --network=app --host=deploy@10.0.0.2", text)
+
+	hostCtx.Facts = map[string]string{"os": "Linux"}
+
+	text, err = hostCtx.Render("{{.User}}@{{.Host}} runs {{.Facts.os}}", envs)
+	assertion.Nil(err)
+	assertion.Equal("deploy@10.0.0.2:22 runs Linux", text)
+
+	_, err = hostCtx.Render("{{.Unknown}}", envs)
+	assertion.NotNil(err)
+}
+
+func Test_ReferencesFacts(t *testing.T) {
+	assertion := assert.New(t)
+
+	for _, text := range []string{
+		"uname -a # {{.Facts.os}}",
+		"{{with .Facts}}{{.os}}{{end}}",
+		"{{range $k, $v := .Facts}}{{$k}}{{end}}",
+		"{{if .Index}}{{$.Facts.arch}}{{end}}",
+		`{{define "os"}}{{.Facts.os}}{{end}}{{template "os" .}}`,
+		"{{(.).Facts.os}}",
+	} {
+		assertion.True(referencesFacts(text), text)
+	}
+
+	for _, text := range []string{
+		"echo .Facts",
+		`echo "{{.Host}} has no .Facts"`,
+		"# see .Facts of {{.Network}}",
+		"{{.Env.Facts}}",
+		"{{.Unclosed",
+	} {
+		assertion.False(referencesFacts(text), text)
+	}
+}
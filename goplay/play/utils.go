@@ -1,11 +1,13 @@
 package play
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -80,6 +82,62 @@ func MaskUserHostWithPasswd(host string) string {
 	})
 }
 
+// MaskSecrets masks all secrets contained by s.
+func MaskSecrets(s string, secrets []string) string {
+	if len(secrets) == 0 {
+		return s
+	}
+
+	// NOTE: longer secrets first, the replacer matches in order of arguments.
+	sorted := make([]string, len(secrets))
+	copy(sorted, secrets)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, secret := range sorted {
+		oldnew = append(oldnew, secret, "***")
+	}
+
+	return strings.NewReplacer(oldnew...).Replace(s)
+}
+
+// NewMaskReader returns a reader which masks all secrets read from r line by line.
+func NewMaskReader(r io.Reader, secrets []string) io.Reader {
+	if len(secrets) == 0 {
+		return r
+	}
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		reader := bufio.NewReader(r)
+
+		for {
+			line, err := reader.ReadString('\n')
+			if len(line) > 0 {
+				_, werr := io.WriteString(pw, MaskSecrets(line, secrets))
+				if werr != nil {
+					pw.CloseWithError(werr)
+					return
+				}
+			}
+
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+
+	return pr
+}
+
 // PadStringWithTimestamp returns new string leads with time
 func PadStringWithTimestamp(s string, n int) string {
 	ts := time.Now().Format("2006/01/02 15:04:05")
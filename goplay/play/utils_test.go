@@ -1,6 +1,8 @@
 package play
 
 import (
+	"io/ioutil"
+	"strings"
 	"testing"
 
 	"github.com/golib/assert"
@@ -13,3 +15,19 @@ func Test_MaskUserHostWithPasswd(t *testing.T) {
 
 	assert.Equal(t, expect, out)
 }
+
+func Test_MaskSecrets(t *testing.T) {
+	secrets := []string{"pass", "password"}
+
+	assert.Equal(t, "+ mysql -u root -p***\n", MaskSecrets("+ mysql -u root -ppassword\n", secrets))
+	assert.Equal(t, "*** and ***", MaskSecrets("pass and password", secrets))
+	assert.Equal(t, "nothing", MaskSecrets("nothing", nil))
+}
+
+func Test_NewMaskReader(t *testing.T) {
+	reader := NewMaskReader(strings.NewReader("line password\nlast pass"), []string{"pass", "password"})
+
+	data, err := ioutil.ReadAll(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "line ***\nlast ***", string(data))
+}
@@ -0,0 +1,194 @@
+package play
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// VaultHeader leads all encrypted values of vault.
+	//
+	// NOTE: yaml.v2 drops custom tags while decoding, so encrypted values are
+	// recognized by the header instead of the !vault tag.
+	VaultHeader = "$GOPLAY_VAULT;1.0;AES256GCM;"
+
+	// VaultTag is the YAML tag for encrypted values of vault.
+	VaultTag = "!vault"
+
+	vaultSaltSize = 16
+	vaultKeySize  = 32
+)
+
+// Vault encrypts and decrypts values with AES-256-GCM, the key is derived
+// from passphrase by scrypt with a random salt for each value.
+type Vault struct {
+	mux        sync.Mutex
+	passphrase []byte
+	plains     map[string]string // Cache of decrypted values, scrypt is slow.
+}
+
+// NewVault returns *Vault with passphrase given.
+func NewVault(passphrase []byte) *Vault {
+	return &Vault{
+		passphrase: passphrase,
+		plains:     make(map[string]string),
+	}
+}
+
+// NewVaultFromFile returns *Vault with passphrase read from filename given.
+// Both random key and passphrase are supported, leading and trailing spaces
+// are ignored.
+func NewVaultFromFile(filename string) (*Vault, error) {
+	data, err := ioutil.ReadFile(path.Clean(filename))
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase := strings.TrimSpace(string(data))
+	if passphrase == "" {
+		return nil, errors.Errorf("vault file %s is empty", filename)
+	}
+
+	return NewVault([]byte(passphrase)), nil
+}
+
+// Encrypt encrypts plain and returns value leading with VaultHeader.
+func (v *Vault) Encrypt(plain string) (string, error) {
+	salt := make([]byte, vaultSaltSize)
+
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return "", errors.Wrap(err, "generating salt failed")
+	}
+
+	aead, err := v.cipher(salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", errors.Wrap(err, "generating nonce failed")
+	}
+
+	data := append(salt, nonce...)
+	data = aead.Seal(data, nonce, []byte(plain), nil)
+
+	return VaultHeader + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Decrypt decrypts value returned by Encrypt.
+func (v *Vault) Decrypt(value string) (string, error) {
+	if !IsVaultValue(value) {
+		return "", errors.New("value is not encrypted by vault")
+	}
+
+	v.mux.Lock()
+	defer v.mux.Unlock()
+
+	if plain, ok := v.plains[value]; ok {
+		return plain, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, VaultHeader))
+	if err != nil {
+		return "", errors.Wrap(err, "decoding value failed")
+	}
+	if len(data) < vaultSaltSize {
+		return "", errors.New("value is too short")
+	}
+
+	aead, err := v.cipher(data[:vaultSaltSize])
+	if err != nil {
+		return "", err
+	}
+
+	data = data[vaultSaltSize:]
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("value is too short")
+	}
+
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.Wrap(err, "decrypting value failed, wrong vault key?")
+	}
+
+	v.plains[value] = string(plain)
+
+	return string(plain), nil
+}
+
+func (v *Vault) cipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(v.passphrase, salt, 1<<15, 8, 1, vaultKeySize)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key failed")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// rvaultValue matches YAML mapping items of values encrypted by vault.
+var rvaultValue = regexp.MustCompile(`(?m)^(\s*[^\s'"#][^:'"]*: )(` + regexp.QuoteMeta(VaultHeader) + `\S*)$`)
+
+// tagVaultValues tags values encrypted by vault of YAML data with VaultTag,
+// for yaml.v2 can't marshal custom tags.
+func tagVaultValues(data []byte) []byte {
+	return rvaultValue.ReplaceAll(data, []byte("${1}"+VaultTag+" ${2}"))
+}
+
+// IsVaultValue returns true if value is encrypted by vault.
+func IsVaultValue(value string) bool {
+	return strings.HasPrefix(value, VaultHeader)
+}
+
+// Decrypt decrypts values encrypted in place and marks them as secret.
+func (e EnvVars) Decrypt(vault *Vault) error {
+	for _, v := range e {
+		if !IsVaultValue(v.Value) {
+			continue
+		}
+
+		if vault == nil {
+			return errors.Errorf("decrypting env var %v failed: vault key is required", v.Key)
+		}
+
+		plain, err := vault.Decrypt(v.Value)
+		if err != nil {
+			return errors.Wrapf(err, "decrypting env var %v failed", v.Key)
+		}
+
+		v.Value = plain
+		v.Secret = true
+	}
+
+	return nil
+}
+
+// Secrets returns values of env vars decrypted from vault.
+func (e EnvVars) Secrets() (secrets []string) {
+	for _, v := range e {
+		if v.Secret && v.Value != "" {
+			secrets = append(secrets, v.Value)
+		}
+	}
+
+	return
+}
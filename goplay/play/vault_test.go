@@ -0,0 +1,123 @@
+package play
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golib/assert"
+)
+
+func Test_Vault(t *testing.T) {
+	assertion := assert.New(t)
+
+	vault := NewVault([]byte("passphrase"))
+
+	encrypted, err := vault.Encrypt(`pa$$"word'`)
+	assertion.Nil(err)
+	assertion.True(IsVaultValue(encrypted))
+
+	plain, err := vault.Decrypt(encrypted)
+	assertion.Nil(err)
+	assertion.Equal(`pa$$"word'`, plain)
+
+	// wrong key
+	_, err = NewVault([]byte("wrong")).Decrypt(encrypted)
+	assertion.NotNil(err)
+
+	// not encrypted
+	_, err = vault.Decrypt("plain")
+	assertion.NotNil(err)
+}
+
+func Test_VaultWithPlayfile(t *testing.T) {
+	assertion := assert.New(t)
+
+	vault := NewVault([]byte("passphrase"))
+
+	encrypted, err := vault.Encrypt(`pa$$"word'`)
+	assertion.Nil(err)
+
+	pfile, err := NewPlayfile([]byte(`---
+version: 1.0.0
+
+envs:
+  DB_USER: root
+  DB_PASS: !vault ` + encrypted + `
+`))
+	assertion.Nil(err)
+	assertion.Equal(encrypted, pfile.Envs[1].Value)
+
+	// vault is required
+	_, err = pfile.EnvsFor(nil, nil, nil)
+	assertion.NotNil(err)
+
+	pfile.SetVault(vault)
+
+	envs, err := pfile.EnvsFor(nil, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal([]string{"DB_USER=root", `DB_PASS=pa$$"word'`}, envs.Slice())
+	assertion.Equal([]string{`pa$$"word'`}, envs.Secrets())
+	assertion.Equal(`export DB_PASS='pa$$"word'\''';`, envs[1].AsExport())
+
+	// decrypted in memory only
+	assertion.Equal(encrypted, pfile.Envs[1].Value)
+}
+
+func Test_VaultWithPlayfileWriteFile(t *testing.T) {
+	assertion := assert.New(t)
+
+	vault := NewVault([]byte("passphrase"))
+
+	encrypted, err := vault.Encrypt(`pa$$"word'`)
+	assertion.Nil(err)
+
+	dir, err := ioutil.TempDir("", "goplay")
+	assertion.Nil(err)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "Playfile.yml")
+	assertion.Nil(ioutil.WriteFile(filename, []byte(`---
+envs:
+  DB_USER: root
+  DB_PASS: !vault `+encrypted+`
+
+networks:
+  db:
+    env:
+      TOKEN: !vault `+encrypted+`
+    hosts:
+      - 127.0.0.1
+`), 0644))
+
+	pfile, err := NewPlayfileFromFile(filename)
+	assertion.Nil(err)
+
+	network, ok := pfile.Networks.Get("db")
+	assertion.True(ok)
+	assertion.True(network.AddHost("127.0.0.2"))
+	assertion.Nil(pfile.Networks.Set("db", network))
+
+	assertion.Nil(pfile.WriteFile(filename))
+
+	data, err := ioutil.ReadFile(filename)
+	assertion.Nil(err)
+	assertion.Contains(string(data), "DB_PASS: !vault "+encrypted+"\n")
+	assertion.Contains(string(data), "TOKEN: !vault "+encrypted+"\n")
+	assertion.NotContains(string(data), "version:")
+
+	saved, err := NewPlayfileFromFile(filename)
+	assertion.Nil(err)
+	assertion.Empty(saved.Version)
+
+	saved.SetVault(vault)
+
+	network, ok = saved.Networks.Get("db")
+	assertion.True(ok)
+	assertion.Equal([]string{"127.0.0.1", "127.0.0.2"}, network.Hosts)
+
+	envs, err := saved.EnvsFor(&network, nil, nil)
+	assertion.Nil(err)
+	assertion.Equal([]string{"DB_USER=root", `DB_PASS=pa$$"word'`, `TOKEN=pa$$"word'`}, envs.Slice())
+}